@@ -0,0 +1,120 @@
+package graphqlws
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// defaultMaxConnectionAgeGrace bounds how long a connection that reached its
+// maximum age waits for single-result operations.
+const defaultMaxConnectionAgeGrace = 10 * time.Second
+
+// rotationReason is the reason in the payload of the ping announcing that a
+// connection reached its maximum age.
+const rotationReason = "max_connection_age"
+
+var errConnectionDraining = errors.New("connection is closing, subscribe on a new connection")
+
+type connectionAge struct {
+	age      time.Duration
+	jitter   time.Duration
+	grace    time.Duration
+	hasGrace bool
+}
+
+// WithMaxConnectionAge recycles connections after age plus a random duration
+// of up to jitter, so that clients spread over the replicas of a service
+// after it scales out.
+//
+// When a connection reaches its age, the server sends a ping with the
+// payload {"reason":"max_connection_age"} and answers further subscribe
+// messages with an error. Once the queries and mutations in flight have
+// finished, or after the grace period set with WithMaxConnectionAgeGrace,
+// the connection is closed with code 1012 (service restart), which tells
+// clients to reconnect and subscribe again. Running subscriptions end with
+// the connection and are not completed.
+func WithMaxConnectionAge(age, jitter time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.connectionAge.age = age
+		o.connectionAge.jitter = max(jitter, 0)
+	})
+}
+
+// WithMaxConnectionAgeGrace bounds how long a connection that reached the
+// age set with WithMaxConnectionAge waits for queries and mutations in
+// flight. The default is 10 seconds.
+func WithMaxConnectionAgeGrace(grace time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.connectionAge.grace = max(grace, 0)
+		o.connectionAge.hasGrace = true
+	})
+}
+
+func transportMaxConnectionAge(a connectionAge) transportOption {
+	return func(conn *connection) {
+		conn.age = a
+	}
+}
+
+// ageTimer returns a channel that fires when the connection reaches its
+// maximum age, and a function to stop it. The channel is nil without a
+// maximum age.
+func (conn *connection) ageTimer() (<-chan time.Time, func() bool) {
+	if conn.age.age <= 0 {
+		return nil, func() bool { return false }
+	}
+
+	age := conn.age.age
+	if conn.age.jitter > 0 {
+		age += rand.N(conn.age.jitter)
+	}
+
+	t := time.NewTimer(age)
+	return t.C, t.Stop
+}
+
+// singleResult reports whether the operation payload selects is a query or
+// mutation rather than a subscription.
+func singleResult(payload subscribeMessagePayload) bool {
+	if payload.document == nil || payload.document.doc == nil {
+		return false
+	}
+
+	op, err := payload.document.doc.operation(payload.OperationName)
+	return err == nil && op.typ != "subscription"
+}
+
+// drain announces that the connection is going away, stops it from starting
+// operations and closes it once the single-result operations in flight have
+// finished or the grace period has passed.
+func (conn *connection) drain(ctx context.Context, send sendFunc) {
+	conn.draining.Store(true)
+	notice, _ := conn.codec.Marshal(map[string]string{"reason": rotationReason})
+	send(&operationMessage{Type: typePing, Payload: notice})
+
+	done := make(chan struct{})
+	go func() {
+		conn.inflight.Wait()
+		close(done)
+	}()
+
+	grace := conn.age.grace
+	if !conn.age.hasGrace {
+		grace = defaultMaxConnectionAgeGrace
+	}
+
+	go func() {
+		t := time.NewTimer(grace)
+		defer t.Stop()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+		case <-t.C:
+		}
+		conn.closeWithCode(closeCodeServiceRestart, "Max connection age")
+	}()
+}
@@ -0,0 +1,87 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMaxConnectionAge(t *testing.T) {
+	t.Parallel()
+
+	t.Run("drains single-result operations", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		h := setupTest(t)
+		h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any)
+			go func() {
+				defer close(c)
+				if document == "subscription { a }" {
+					<-ctx.Done()
+					return
+				}
+				<-release
+				c <- map[string]string{"q": "done"}
+			}()
+			return c, nil
+		}
+
+		go connectTransport(context.Background(), h.conn, h.mockSvc, transportMaxConnectionAge(connectionAge{age: 50 * time.Millisecond, grace: time.Second, hasGrace: true}))
+		defer close(h.conn.in)
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+		h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`)
+		h.conn.in <- json.RawMessage(`{"id":"2","type":"subscribe","payload":{"query":"query { q }"}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+		requireEqualJSON(t, `{"type":"ping","payload":{"reason":"max_connection_age"}}`, requireMessage(t, h.conn), "")
+
+		h.conn.in <- json.RawMessage(`{"id":"3","type":"subscribe","payload":{"query":"query { q }"}}`)
+		requireEqualJSON(t, `{"id":"3","type":"error","payload":[{"message":"connection is closing, subscribe on a new connection"}]}`, requireMessage(t, h.conn), "")
+
+		// The query in flight keeps the connection open until it finishes.
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case <-h.conn.closeCalled:
+			t.Fatal("expected the connection to wait for the query in flight")
+		default:
+		}
+
+		close(release)
+		requireEqualJSON(t, `{"id":"2","type":"next","payload":{"q":"done"}}`, requireMessage(t, h.conn), "")
+		requireMessageType(t, requireMessage(t, h.conn), "complete")
+		requireClosed(t, h.conn)
+
+		h.conn.mtx.Lock()
+		defer h.conn.mtx.Unlock()
+		if h.conn.closeCode != closeCodeServiceRestart {
+			t.Fatalf("want close code %d, got %d", closeCodeServiceRestart, h.conn.closeCode)
+		}
+	})
+
+	t.Run("grace period", func(t *testing.T) {
+		t.Parallel()
+
+		h := setupTest(t)
+		h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any)
+			go func() {
+				<-ctx.Done()
+				close(c)
+			}()
+			return c, nil
+		}
+
+		go connectTransport(context.Background(), h.conn, h.mockSvc, transportMaxConnectionAge(connectionAge{age: 10 * time.Millisecond, jitter: 10 * time.Millisecond, grace: 30 * time.Millisecond, hasGrace: true}))
+		defer close(h.conn.in)
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+		h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"mutation { m }"}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+		requireMessageType(t, requireMessage(t, h.conn), "ping")
+		requireClosed(t, h.conn)
+	})
+}
@@ -0,0 +1,219 @@
+package graphqlws
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"time"
+)
+
+// Terminal statuses of audited operations.
+const (
+	// AuditComplete means the Subscriber ended the operation.
+	AuditComplete = "complete"
+
+	// AuditError means the operation ended with an error message.
+	AuditError = "error"
+
+	// AuditTimeout means the operation was ended by
+	// WithOperationMaxDuration or WithOperationIdleTimeout.
+	AuditTimeout = "timeout"
+
+	// AuditCanceled means the client completed the operation or the
+	// connection closed.
+	AuditCanceled = "canceled"
+)
+
+// redactedValue replaces variable values by default.
+const redactedValue = "[REDACTED]"
+
+// AuditRecord describes an operation once it has ended.
+type AuditRecord struct {
+	ConnectionID string `json:"connectionId"`
+
+	// RemoteAddr is the network address of the client, if the Conn
+	// exposes it.
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+
+	// Identity identifies the client, as returned by the function set
+	// with WithAuditIdentity.
+	Identity string `json:"identity,omitempty"`
+
+	OperationID   string `json:"operationId"`
+	OperationName string `json:"operationName,omitempty"`
+
+	// DocumentHash is the hex encoded SHA-256 hash of the document.
+	DocumentHash string `json:"documentHash"`
+
+	// Variables are the variables of the operation, redacted as set
+	// with WithAuditRedaction.
+	Variables map[string]any `json:"variables,omitempty"`
+
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Messages and Bytes count the next messages sent and the size of
+	// their payloads.
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+
+	// Status is AuditComplete, AuditError, AuditTimeout or
+	// AuditCanceled.
+	Status string `json:"status"`
+
+	// Error is the message of the error ending the operation, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink receives a record of every operation that ends. Record is
+// called from the goroutine of the operation and should return quickly.
+type AuditSink interface {
+	Record(rec AuditRecord)
+}
+
+type auditOptions struct {
+	sink     AuditSink
+	identity func(context.Context) string
+	redact   func(map[string]any) map[string]any
+}
+
+// WithAuditSink records every operation to sink when it ends. Variable values
+// are redacted unless set otherwise with WithAuditRedaction.
+func WithAuditSink(sink AuditSink) Option {
+	return optionFunc(func(o *options) {
+		o.audit.sink = sink
+	})
+}
+
+// WithAuditIdentity sets the function deriving the AuditRecord.Identity of
+// an operation from its context, such as the user set by the InitFunc.
+func WithAuditIdentity(identity func(ctx context.Context) string) Option {
+	return optionFunc(func(o *options) {
+		o.audit.identity = identity
+	})
+}
+
+// WithAuditRedaction sets the function returning the AuditRecord.Variables
+// for the variables of an operation. It must not modify vars. By default,
+// every value is replaced with "[REDACTED]".
+func WithAuditRedaction(redact func(vars map[string]any) map[string]any) Option {
+	return optionFunc(func(o *options) {
+		o.audit.redact = redact
+	})
+}
+
+func transportAudit(a auditOptions) transportOption {
+	return func(conn *connection) {
+		if a.redact == nil {
+			a.redact = redactVariables
+		}
+		conn.audit = &a
+		conn.identify()
+	}
+}
+
+// identify assigns the connection an ID and records the address of the
+// client, if the Conn exposes it.
+func (conn *connection) identify() {
+	if conn.id != "" {
+		return
+	}
+
+	conn.id = newConnectionID()
+	if ra, ok := conn.ws.(interface{ RemoteAddr() net.Addr }); ok && ra.RemoteAddr() != nil {
+		conn.remoteAddr = ra.RemoteAddr().String()
+	}
+}
+
+func newConnectionID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// redactVariables replaces the value of every variable.
+func redactVariables(vars map[string]any) map[string]any {
+	if len(vars) == 0 {
+		return nil
+	}
+
+	redacted := make(map[string]any, len(vars))
+	for name := range vars {
+		redacted[name] = redactedValue
+	}
+	return redacted
+}
+
+// operationAudit collects the AuditRecord of an operation.
+type operationAudit struct {
+	rec AuditRecord
+}
+
+// startAudit starts the record of the operation id. It returns nil without
+// an AuditSink.
+func (conn *connection) startAudit(ctx context.Context, id string, payload subscribeMessagePayload) *operationAudit {
+	if conn.audit == nil {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(payload.Query))
+	a := &operationAudit{rec: AuditRecord{
+		ConnectionID:  conn.id,
+		RemoteAddr:    conn.remoteAddr,
+		OperationID:   id,
+		OperationName: payload.OperationName,
+		DocumentHash:  hex.EncodeToString(sum[:]),
+		Variables:     conn.audit.redact(payload.Variables),
+		Start:         time.Now(),
+		Status:        AuditCanceled,
+	}}
+	if conn.audit.identity != nil {
+		a.rec.Identity = conn.audit.identity(ctx)
+	}
+
+	return a
+}
+
+// wrap returns a sendFunc that passes messages to send and records those
+// that were queued.
+func (a *operationAudit) wrap(send sendFunc, codec Codec) sendFunc {
+	return func(msg *operationMessage) bool {
+		if !send(msg) {
+			return false
+		}
+
+		switch msg.Type {
+		case typeNext:
+			a.rec.Messages++
+			a.rec.Bytes += int64(len(msg.Payload))
+		case typeComplete:
+			a.rec.Status = AuditComplete
+		case typeError:
+			a.rec.Status = AuditError
+
+			var errs []struct {
+				Message string `json:"message"`
+			}
+			if err := codec.Unmarshal(msg.Payload, &errs); err == nil && len(errs) > 0 {
+				a.rec.Error = errs[0].Message
+			}
+		}
+
+		return true
+	}
+}
+
+// timeout marks the operation as ended by a timeout.
+func (a *operationAudit) timeout() {
+	if a != nil {
+		a.rec.Status = AuditTimeout
+	}
+}
+
+// finish records the operation to sink.
+func (a *operationAudit) finish(sink AuditSink) {
+	a.rec.End = time.Now()
+	sink.Record(a.rec)
+}
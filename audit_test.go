@@ -0,0 +1,174 @@
+package graphqlws
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// auditRecorder is an AuditSink keeping the records in memory.
+type auditRecorder struct {
+	mu      sync.Mutex
+	records []AuditRecord
+	added   chan struct{}
+}
+
+func newAuditRecorder() *auditRecorder {
+	return &auditRecorder{added: make(chan struct{}, 10)}
+}
+
+func (r *auditRecorder) Record(rec AuditRecord) {
+	r.mu.Lock()
+	r.records = append(r.records, rec)
+	r.mu.Unlock()
+	r.added <- struct{}{}
+}
+
+// next returns the next record.
+func (r *auditRecorder) next(t *testing.T) AuditRecord {
+	t.Helper()
+
+	select {
+	case <-r.added:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an audit record")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.records[0]
+	r.records = r.records[1:]
+	return rec
+}
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	type userKey struct{}
+
+	sink := newAuditRecorder()
+	h := setupTest(t)
+	h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+		switch operationName {
+		case "Fail":
+			return nil, errors.New("boom")
+		case "Wait":
+			c := make(chan any)
+			go func() {
+				<-ctx.Done()
+				close(c)
+			}()
+			return c, nil
+		}
+
+		c := make(chan any, 2)
+		c <- "ab"
+		c <- "cd"
+		close(c)
+		return c, nil
+	}
+
+	audit := auditOptions{
+		sink: sink,
+		identity: func(ctx context.Context) string {
+			user, _ := ctx.Value(userKey{}).(string)
+			return user
+		},
+	}
+	ctx := context.WithValue(context.Background(), userKey{}, "alice")
+	go connectTransport(ctx, h.conn, h.mockSvc, transportAudit(audit))
+	defer close(h.conn.in)
+
+	h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+	requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+	query := "subscription Count($token: String) { count }"
+	h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"` + query + `","operationName":"Count","variables":{"token":"secret"}}}`)
+	requireMessageType(t, requireMessage(t, h.conn), "next")
+	requireMessageType(t, requireMessage(t, h.conn), "next")
+	requireMessageType(t, requireMessage(t, h.conn), "complete")
+
+	rec := sink.next(t)
+	sum := sha256.Sum256([]byte(query))
+	if rec.ConnectionID == "" || rec.Identity != "alice" || rec.OperationID != "1" || rec.OperationName != "Count" ||
+		rec.DocumentHash != hex.EncodeToString(sum[:]) || rec.Variables["token"] != redactedValue ||
+		rec.Messages != 2 || rec.Bytes != int64(len(`"ab"`)+len(`"cd"`)) || rec.Status != AuditComplete ||
+		rec.Start.IsZero() || rec.End.Before(rec.Start) {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	connectionID := rec.ConnectionID
+
+	h.conn.in <- json.RawMessage(`{"id":"2","type":"subscribe","payload":{"query":"subscription Fail { a }","operationName":"Fail"}}`)
+	requireMessageType(t, requireMessage(t, h.conn), "error")
+	if rec := sink.next(t); rec.Status != AuditError || rec.Error != "boom" || rec.ConnectionID != connectionID {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+
+	h.conn.in <- json.RawMessage(`{"id":"3","type":"subscribe","payload":{"query":"subscription Wait { a }","operationName":"Wait"}}`)
+	h.mockSvc.waitForCalls(3)
+	h.conn.in <- json.RawMessage(`{"id":"3","type":"complete"}`)
+	if rec := sink.next(t); rec.Status != AuditCanceled || rec.Messages != 0 {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	rec := AuditRecord{ConnectionID: "c", OperationID: "1", Status: AuditComplete}
+	line, _ := json.Marshal(rec)
+	size := int64(len(line) + 1)
+
+	// Two records fit in a file, and two backups are kept.
+	f, err := NewAuditFile(path, 2*size, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 7 {
+		rec.OperationID = string(rune('1' + i))
+		f.Record(rec)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		path:        {"7"},
+		path + ".1": {"5", "6"},
+		path + ".2": {"3", "4"},
+	}
+	for name, ids := range want {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		s := bufio.NewScanner(file)
+		for s.Scan() {
+			var rec AuditRecord
+			if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, rec.OperationID)
+		}
+		file.Close()
+
+		if len(got) != len(ids) || got[0] != ids[0] || got[len(got)-1] != ids[len(ids)-1] {
+			t.Fatalf("%s: want records %q, got %q", filepath.Base(name), ids, got)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected no third backup, got %v", err)
+	}
+}
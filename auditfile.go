@@ -0,0 +1,152 @@
+package graphqlws
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// AuditFile is an AuditSink that appends records to a file as JSON lines and
+// rotates the file when it reaches a maximum size.
+type AuditFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	err    error // the first error writing or rotating the file
+	closed bool
+}
+
+var _ AuditSink = (*AuditFile)(nil)
+
+// NewAuditFile opens, or creates, the file at path for appending records.
+// When a record would grow the file beyond maxSize bytes, the file is
+// renamed to path.1, older files are renamed from path.N to path.N+1, and
+// files beyond maxBackups are removed. A maxSize of 0 disables rotation.
+func NewAuditFile(path string, maxSize int64, maxBackups int) (*AuditFile, error) {
+	a := &AuditFile{path: path, maxSize: maxSize, maxBackups: max(maxBackups, 0)}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Record appends rec to the file. Errors are reported by Close.
+func (a *AuditFile) Record(rec AuditRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return
+	}
+
+	if a.f == nil {
+		// A previous rotation failed.
+		if err := a.open(); err != nil {
+			a.setErr(err)
+			return
+		}
+	}
+
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			a.setErr(err)
+			return
+		}
+	}
+
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	a.setErr(err)
+}
+
+// Close closes the file and returns the first error that occurred while
+// recording, if any.
+func (a *AuditFile) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return a.err
+	}
+	a.closed = true
+
+	if a.f != nil {
+		a.setErr(a.f.Close())
+	}
+
+	return a.err
+}
+
+func (a *AuditFile) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	a.f, a.size = f, info.Size()
+	return nil
+}
+
+// rotate moves the current file to the first backup and opens a new one.
+func (a *AuditFile) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	a.f = nil
+
+	for i := a.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(a.backup(i), a.backup(i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	var err error
+	if a.maxBackups > 0 {
+		err = os.Rename(a.path, a.backup(1))
+	} else {
+		err = os.Remove(a.path)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return a.open()
+}
+
+func (a *AuditFile) backup(n int) string {
+	return a.path + "." + strconv.Itoa(n)
+}
+
+func (a *AuditFile) fail(err error) {
+	a.mu.Lock()
+	a.setErr(err)
+	a.mu.Unlock()
+}
+
+func (a *AuditFile) setErr(err error) {
+	if a.err == nil {
+		a.err = err
+	}
+}
@@ -0,0 +1,109 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// InitFunc validates the credentials in a connection_init payload, or those
+// refreshing them in a ping. It returns the connection context for the
+// operations started afterwards, derived from ctx, and the time the
+// credentials expire. The zero time means they do not expire. A non-nil
+// error rejects the credentials.
+type InitFunc func(ctx context.Context, payload map[string]any) (context.Context, time.Time, error)
+
+// WithInitFunc validates connection_init payloads with f. Connections whose
+// credentials are rejected are closed with code 4403 (forbidden). Connections
+// whose credentials expire are closed with code 4401 (unauthorized) at
+// expiry, unless the client refreshes them as set up with WithRefreshFunc.
+func WithInitFunc(f InitFunc) Option {
+	return optionFunc(func(o *options) {
+		o.initFunc = f
+	})
+}
+
+// WithRefreshFunc lets clients refresh their credentials before they expire
+// by sending a ping whose payload carries new credentials in its credentials
+// member, e.g. {"credentials":{"token":"..."}}. f validates the credentials
+// like the InitFunc validates the connection_init payload and is called with
+// the same context. On success, the expiry is moved to the returned time and
+// operations started afterwards use the values of the returned context;
+// running operations keep theirs. Rejected credentials are ignored, and the
+// connection is closed when the current ones expire.
+//
+// Other pings are answered as usual and do not call f.
+func WithRefreshFunc(f InitFunc) Option {
+	return optionFunc(func(o *options) {
+		o.refreshFunc = f
+	})
+}
+
+func transportAuth(init, refresh InitFunc) transportOption {
+	return func(conn *connection) {
+		conn.initFunc = init
+		conn.refreshFunc = refresh
+	}
+}
+
+// refreshCredentials validates the credentials in the payload of a ping with
+// the refresh function. It reports false if the payload carries no
+// credentials or they were rejected.
+func (conn *connection) refreshCredentials(ctx context.Context, payload json.RawMessage) (context.Context, time.Time, bool) {
+	if len(payload) == 0 {
+		return nil, time.Time{}, false
+	}
+
+	var ping struct {
+		Credentials map[string]any `json:"credentials"`
+	}
+	if err := conn.codec.Unmarshal(payload, &ping); err != nil || ping.Credentials == nil {
+		return nil, time.Time{}, false
+	}
+
+	refreshed, expiry, err := conn.refreshFunc(ctx, ping.Credentials)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+
+	return refreshed, expiry, true
+}
+
+// valuesContext has the cancellation of its Context and the values of
+// values.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key any) any {
+	return c.values.Value(key)
+}
+
+// expiryTimer fires when the credentials of a connection expire.
+type expiryTimer struct {
+	t *time.Timer
+}
+
+// reset makes the timer fire at, or never if at is the zero time.
+func (e *expiryTimer) reset(at time.Time) {
+	e.stop()
+	if !at.IsZero() {
+		e.t = time.NewTimer(time.Until(at))
+	}
+}
+
+// expired returns the channel the timer fires on, nil if it never fires.
+func (e *expiryTimer) expired() <-chan time.Time {
+	if e.t == nil {
+		return nil
+	}
+	return e.t.C
+}
+
+func (e *expiryTimer) stop() {
+	if e.t != nil {
+		e.t.Stop()
+		e.t = nil
+	}
+}
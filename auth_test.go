@@ -0,0 +1,147 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCredentialExpiry(t *testing.T) {
+	t.Parallel()
+
+	type userKey struct{}
+
+	// validate accepts the tokens "a" and "b", which expire after ttl.
+	validate := func(ttl time.Duration) InitFunc {
+		return func(ctx context.Context, payload map[string]any) (context.Context, time.Time, error) {
+			token, _ := payload["token"].(string)
+			if token != "a" && token != "b" {
+				return nil, time.Time{}, errors.New("invalid token")
+			}
+			return context.WithValue(ctx, userKey{}, token), time.Now().Add(ttl), nil
+		}
+	}
+
+	requireCloseCode := func(t *testing.T, conn *mockConnection, want int) {
+		t.Helper()
+
+		requireClosed(t, conn)
+		conn.mtx.Lock()
+		defer conn.mtx.Unlock()
+		if conn.closeCode != want {
+			t.Fatalf("want close code %d, got %d", want, conn.closeCode)
+		}
+	}
+
+	t.Run("rejected init", func(t *testing.T) {
+		t.Parallel()
+
+		h := setupTest(t)
+		go connectTransport(context.Background(), h.conn, h.mockSvc, transportAuth(validate(time.Hour), nil))
+		defer close(h.conn.in)
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init","payload":{"token":"x"}}`)
+		requireCloseCode(t, h.conn, closeCodeForbidden)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		t.Parallel()
+
+		h := setupTest(t)
+		go connectTransport(context.Background(), h.conn, h.mockSvc, transportAuth(validate(50*time.Millisecond), nil))
+		defer close(h.conn.in)
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init","payload":{"token":"a"}}`)
+		h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+		requireMessageType(t, requireMessage(t, h.conn), "complete")
+
+		if user := h.mockSvc.getCalls()[0].ctx.Value(userKey{}); user != "a" {
+			t.Fatalf("expected the operation context of user a, got %v", user)
+		}
+
+		requireCloseCode(t, h.conn, closeCodeUnauthorized)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		t.Parallel()
+
+		h := setupTest(t)
+		go connectTransport(context.Background(), h.conn, h.mockSvc, transportAuth(validate(50*time.Millisecond), validate(time.Hour)))
+		defer close(h.conn.in)
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init","payload":{"token":"a"}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+		// Heartbeats with a payload do not refresh the credentials.
+		h.conn.in <- json.RawMessage(`{"type":"ping"}`)
+		requireEqualJSON(t, `{"type":"pong"}`, requireMessage(t, h.conn), "")
+		h.conn.in <- json.RawMessage(`{"type":"ping","payload":{"token":"x"}}`)
+		requireEqualJSON(t, `{"type":"pong","payload":{"token":"x"}}`, requireMessage(t, h.conn), "")
+
+		h.conn.in <- json.RawMessage(`{"type":"ping","payload":{"credentials":{"token":"b"}}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "pong")
+
+		// Rejected credentials leave the refreshed ones in place.
+		h.conn.in <- json.RawMessage(`{"type":"ping","payload":{"credentials":{"token":"x"}}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "pong")
+
+		// The credentials of the init payload have expired by now.
+		time.Sleep(100 * time.Millisecond)
+		h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "complete")
+
+		if user := h.mockSvc.getCalls()[0].ctx.Value(userKey{}); user != "b" {
+			t.Fatalf("expected the operation context of user b, got %v", user)
+		}
+	})
+
+	t.Run("rejected refresh", func(t *testing.T) {
+		t.Parallel()
+
+		h := setupTest(t)
+		go connectTransport(context.Background(), h.conn, h.mockSvc, transportAuth(validate(50*time.Millisecond), validate(time.Hour)))
+		defer close(h.conn.in)
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init","payload":{"token":"a"}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+		h.conn.in <- json.RawMessage(`{"type":"ping","payload":{"credentials":{"token":"x"}}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "pong")
+
+		requireCloseCode(t, h.conn, closeCodeUnauthorized)
+	})
+
+	t.Run("refresh with sessions", func(t *testing.T) {
+		t.Parallel()
+
+		h := setupTest(t)
+		store := newSessionStore(time.Minute, 10)
+		go connectTransport(context.Background(), h.conn, h.mockSvc, transportAuth(validate(time.Hour), validate(time.Hour)), transportSessions(store))
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init","payload":{"token":"a"}}`)
+		requireSessionAck(t, h.conn)
+
+		h.conn.in <- json.RawMessage(`{"type":"ping","payload":{"credentials":{"token":"b"}}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "pong")
+		h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "complete")
+
+		ctx := h.mockSvc.getCalls()[0].ctx
+		if user := ctx.Value(userKey{}); user != "b" {
+			t.Fatalf("expected the operation context of user b, got %v", user)
+		}
+
+		// A client close ends the session and its operations.
+		h.conn.in <- json.RawMessage(`{"type":"banana"}`)
+		requireClosed(t, h.conn)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("expected the operation context to end with the session")
+		}
+		close(h.conn.in)
+	})
+}
@@ -0,0 +1,246 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/ast"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// errorCodeForbidden is the extensions.code of the error rejecting an
+// operation the Authorizer denied.
+const errorCodeForbidden = "FORBIDDEN"
+
+// maxAuthorizedSelections bounds the selections built for an
+// AuthorizationRequest, so that documents spreading fragments repeatedly
+// cannot make authorization expensive.
+const maxAuthorizedSelections = 10000
+
+var errTooManySelections = errors.New("operation selects too many fields to be authorized")
+
+// Authorizer decides whether a client may start an operation.
+type Authorizer interface {
+	// Authorize is called with the connection context, which carries
+	// the identity established by the InitFunc or the context
+	// generators. A non-nil error denies the operation.
+	Authorize(ctx context.Context, req *AuthorizationRequest) error
+}
+
+// AuthorizerFunc is an Authorizer implemented by a function.
+type AuthorizerFunc func(ctx context.Context, req *AuthorizationRequest) error
+
+// Authorize calls f(ctx, req).
+func (f AuthorizerFunc) Authorize(ctx context.Context, req *AuthorizationRequest) error {
+	return f(ctx, req)
+}
+
+// AuthorizationRequest describes an operation to the Authorizer.
+//
+// The operation is described by its Selections rather than a syntax tree,
+// since graphql-go does not export its document AST: fields carry the type
+// they are selected on and their arguments with variables substituted, and
+// fragments are replaced by the fields they select. Query holds the source
+// for authorizers that need to parse it themselves.
+type AuthorizationRequest struct {
+	// OperationID is the ID the client chose for the operation.
+	OperationID string
+
+	// OperationName is the name of the operation, if any.
+	OperationName string
+
+	// OperationType is query, mutation or subscription.
+	OperationType string
+
+	Query     string
+	Variables map[string]any
+
+	// Selections are the top-level fields of the operation. Selections
+	// may be shared between requests and must not be modified.
+	Selections []*Selection
+}
+
+// Selection is a field selected by an operation, with the fields selected
+// on its value. Fragments are replaced by the fields they select.
+type Selection struct {
+	Field
+	Selections []*Selection
+}
+
+// WithAuthorizer calls a for every subscribe message, before the operation
+// starts. A denied operation receives an error message with
+// extensions.code FORBIDDEN and the connection stays open. Documents that
+// cannot be parsed are denied.
+//
+// a is called synchronously while reading client messages, so it should
+// return quickly.
+func WithAuthorizer(a Authorizer) Option {
+	return optionFunc(func(o *options) {
+		o.authorizer = a
+	})
+}
+
+// WithAuthorizationHook calls f with every decision of the Authorizer, for
+// example to audit them. err is nil for allowed operations.
+func WithAuthorizationHook(f func(ctx context.Context, req *AuthorizationRequest, err error)) Option {
+	return optionFunc(func(o *options) {
+		o.authorizationHook = f
+	})
+}
+
+func transportAuthorizer(a Authorizer, hook func(context.Context, *AuthorizationRequest, error), schema *graphql.Schema) transportOption {
+	return func(conn *connection) {
+		conn.authorizer = a
+		conn.authorizationHook = hook
+		conn.authorizationSchema = schema
+	}
+}
+
+// authorize asks the Authorizer whether the operation id may start. It
+// returns the error payload rejecting the operation, or nil.
+func (conn *connection) authorize(ctx context.Context, id string, payload subscribeMessagePayload) json.RawMessage {
+	req := &AuthorizationRequest{
+		OperationID:   id,
+		OperationName: payload.OperationName,
+		Query:         payload.Query,
+		Variables:     payload.Variables,
+	}
+
+	err := conn.buildAuthorizationRequest(req, payload.document)
+	denied := false
+	if err == nil {
+		err = conn.authorizer.Authorize(ctx, req)
+		denied = err != nil
+	}
+
+	if conn.authorizationHook != nil {
+		conn.authorizationHook(ctx, req, err)
+	}
+
+	var qErr *gqlerrors.QueryError
+	switch {
+	case err == nil:
+		return nil
+	case denied:
+		return conn.errPayloadCode(err, errorCodeForbidden)
+	case errors.As(err, &qErr):
+		b, _ := conn.codec.Marshal([]*gqlerrors.QueryError{qErr})
+		return b
+	}
+	return conn.errPayload(err)
+}
+
+// buildAuthorizationRequest fills in the operation of req selected in its
+// parsed document d.
+func (conn *connection) buildAuthorizationRequest(req *AuthorizationRequest, d *validatedDocument) error {
+	if d.doc == nil {
+		return d.errs[0]
+	}
+	doc := d.doc
+
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return err
+	}
+	req.OperationType = op.typ
+
+	b := &selectionBuilder{
+		doc:       doc,
+		vars:      req.Variables,
+		fragments: make(map[string][]*Selection),
+	}
+
+	var root ast.NamedType
+	if conn.authorizationSchema != nil {
+		b.schema = conn.authorizationSchema.ASTSchema()
+		root = b.schema.RootOperationTypes[op.typ]
+	}
+
+	req.Selections = b.build(root, op.selections)
+	if b.size > maxAuthorizedSelections {
+		return errTooManySelections
+	}
+
+	return nil
+}
+
+// selectionBuilder builds the Selections of an operation. The selections of
+// each fragment are built once and shared between its spreads.
+type selectionBuilder struct {
+	schema *ast.Schema // nil if the schema is unknown
+	doc    *document
+	vars   map[string]any
+	size   int
+
+	fragments map[string][]*Selection // nil while a fragment is built
+}
+
+func (b *selectionBuilder) build(typ ast.NamedType, selections []*selection) []*Selection {
+	var out []*Selection
+
+	for _, s := range selections {
+		if b.size > maxAuthorizedSelections {
+			return out
+		}
+
+		switch {
+		case s.field != "":
+			def := fieldDefinition(typ, s.field)
+
+			var fieldType ast.NamedType
+			if def != nil {
+				fieldType = namedType(def.Type)
+			}
+
+			sel := &Selection{Field: Field{Name: s.field}}
+			if typ != nil {
+				sel.Type = typ.TypeName()
+			}
+			if s.arguments != nil {
+				sel.Arguments = resolveVariables(s.arguments, b.vars).(map[string]any)
+			}
+			sel.Selections = b.build(fieldType, s.selections)
+
+			b.size++
+			out = append(out, sel)
+
+		case s.fragment != "":
+			fragment := b.fragment(s.fragment)
+			b.size += len(fragment)
+			out = append(out, fragment...)
+
+		default:
+			fragmentType := typ
+			if s.typeCondition != "" && b.schema != nil {
+				fragmentType = b.schema.Types[s.typeCondition]
+			}
+			out = append(out, b.build(fragmentType, s.selections)...)
+		}
+	}
+
+	return out
+}
+
+func (b *selectionBuilder) fragment(name string) []*Selection {
+	if selections, ok := b.fragments[name]; ok {
+		return selections // nil for a cycle, which validation rejects
+	}
+
+	f, ok := b.doc.fragments[name]
+	if !ok {
+		return nil
+	}
+
+	var typ ast.NamedType
+	if b.schema != nil {
+		typ = b.schema.Types[f.typeCondition]
+	}
+
+	b.fragments[name] = nil
+	selections := b.build(typ, f.selections)
+	b.fragments[name] = selections
+
+	return selections
+}
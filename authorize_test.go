@@ -0,0 +1,157 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+func TestAuthorizer(t *testing.T) {
+	t.Parallel()
+
+	type userKey struct{}
+
+	// authorizer lets only admins select secret fields.
+	authorizer := AuthorizerFunc(func(ctx context.Context, req *AuthorizationRequest) error {
+		var walk func([]*Selection) error
+		walk = func(selections []*Selection) error {
+			for _, s := range selections {
+				if s.Name == "secret" && ctx.Value(userKey{}) != "admin" {
+					return errors.New("not allowed to select secret")
+				}
+				if err := walk(s.Selections); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return walk(req.Selections)
+	})
+
+	var (
+		mu        sync.Mutex
+		decisions []string
+	)
+	hook := func(ctx context.Context, req *AuthorizationRequest, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		decision := req.OperationID + ":allowed"
+		if err != nil {
+			decision = req.OperationID + ":" + err.Error()
+		}
+		decisions = append(decisions, decision)
+	}
+
+	h := setupTest(t)
+	ctx := context.WithValue(context.Background(), userKey{}, "guest")
+	go connectTransport(ctx, h.conn, h.mockSvc, transportAuthorizer(authorizer, hook, nil))
+	defer close(h.conn.in)
+
+	h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+	requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+	h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { a { ...F } } fragment F on A { secret }"}}`)
+	requireEqualJSON(t, `{"id":"1","type":"error","payload":[{"message":"not allowed to select secret","extensions":{"code":"FORBIDDEN"}}]}`, requireMessage(t, h.conn), "")
+
+	h.conn.in <- json.RawMessage(`{"id":"2","type":"subscribe","payload":{"query":"subscription { a {"}}`)
+	requireEqualJSON(t, `{"id":"2","type":"error","payload":[{"message":"syntax error: unexpected end of document, expecting a name","locations":[{"line":1,"column":19}]}]}`, requireMessage(t, h.conn), "")
+
+	// The connection stays open for allowed operations.
+	h.conn.in <- json.RawMessage(`{"id":"3","type":"subscribe","payload":{"query":"subscription { a { b } }"}}`)
+	requireEqualJSON(t, `{"id":"3","type":"complete"}`, requireMessage(t, h.conn), "")
+
+	if calls := h.mockSvc.getCalls(); len(calls) != 1 {
+		t.Fatalf("expected only the allowed operation to be subscribed, got %d calls", len(calls))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"1:not allowed to select secret", "2:graphql: syntax error: unexpected end of document, expecting a name (line 1, column 19)", "3:allowed"}
+	if len(decisions) != len(want) {
+		t.Fatalf("want decisions %q, got %q", want, decisions)
+	}
+	for i := range want {
+		if decisions[i] != want[i] {
+			t.Fatalf("want decisions %q, got %q", want, decisions)
+		}
+	}
+}
+
+func TestAuthorizerSharesParsedDocuments(t *testing.T) {
+	t.Parallel()
+
+	authorizer := AuthorizerFunc(func(ctx context.Context, req *AuthorizationRequest) error {
+		return nil
+	})
+	o := applyOptions(WithAuthorizer(authorizer), WithMaxConnectionAge(time.Hour, 0))
+
+	h := setupTest(t)
+	go connectTransport(context.Background(), h.conn, h.mockSvc, o.transportOptions(h.mockSvc)...)
+	defer close(h.conn.in)
+
+	h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+	requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+	for _, id := range []string{"1", "2"} {
+		h.conn.in <- json.RawMessage(`{"id":"` + id + `","type":"subscribe","payload":{"query":"query { a }"}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "complete")
+	}
+
+	o.documents.mu.Lock()
+	defer o.documents.mu.Unlock()
+	if n := o.documents.order.Len(); n != 1 {
+		t.Fatalf("want the document parsed once, got %d cache entries", n)
+	}
+}
+
+func TestAuthorizationRequest(t *testing.T) {
+	t.Parallel()
+
+	conn := &connection{authorizationSchema: graphql.MustParseSchema(costSchema, &costResolver{})}
+	req := &AuthorizationRequest{
+		OperationName: "S",
+		Query: `query Q { hello }
+			subscription S($n: Int!) { posts { ...P ... on Node { id } } }
+			fragment P on Post { author { posts(first: $n) { title } } }`,
+		Variables: map[string]any{"n": float64(3)},
+	}
+	if err := conn.buildAuthorizationRequest(req, conn.parse(req.Query)); err != nil {
+		t.Fatal(err)
+	}
+
+	if req.OperationType != "subscription" {
+		t.Fatalf("want a subscription, got %q", req.OperationType)
+	}
+
+	b, err := json.Marshal(req.Selections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"Type":"Subscription","Name":"posts","Arguments":null,"Selections":[
+		{"Type":"Post","Name":"author","Arguments":null,"Selections":[
+			{"Type":"User","Name":"posts","Arguments":{"first":3},"Selections":[
+				{"Type":"Post","Name":"title","Arguments":null,"Selections":null}]}]},
+		{"Type":"Node","Name":"id","Arguments":null,"Selections":null}]}]`
+	requireEqualJSON(t, want, b, "")
+
+	t.Run("fragment bomb", func(t *testing.T) {
+		t.Parallel()
+
+		// Each fragment spreads the previous one twice.
+		query := "subscription { ...F40 }\nfragment F0 on Subscription { a }\n"
+		for i := 1; i <= 40; i++ {
+			query += "fragment F" + strconv.Itoa(i) + " on Subscription { ...F" + strconv.Itoa(i-1) + " ...F" + strconv.Itoa(i-1) + " }\n"
+		}
+
+		conn := &connection{}
+		err := conn.buildAuthorizationRequest(&AuthorizationRequest{Query: query}, conn.parse(query))
+		if !errors.Is(err, errTooManySelections) {
+			t.Fatalf("want %v, got %v", errTooManySelections, err)
+		}
+	})
+}
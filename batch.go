@@ -0,0 +1,195 @@
+package graphqlws
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// WithWriteBatching coalesces outgoing messages into batches that are
+// written to the network together. Once a message is ready to be written,
+// up to maxSize-1 further queued messages are added to its batch, waiting at
+// most maxLatency for them to arrive. Each message is still sent in its own
+// WebSocket frame, but the frames of a batch are flushed with a single write.
+//
+// Batching trades latency for throughput on connections with many small,
+// frequent messages. With a maxLatency of 0 only messages that are already
+// queued are batched, which adds no latency. Pass a maxSize of 0 or 1 to
+// write every message on its own, which is the default.
+func WithWriteBatching(maxSize int, maxLatency time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.batch = &batchOptions{maxSize: max(maxSize, 0), maxLatency: max(maxLatency, 0)}
+	})
+}
+
+type batchOptions struct {
+	maxSize    int
+	maxLatency time.Duration
+}
+
+// transportWriteBatching batches outgoing messages as configured by b.
+func transportWriteBatching(b batchOptions) transportOption {
+	return func(conn *connection) {
+		conn.batch = b
+	}
+}
+
+// batchWriter is implemented by connections that buffer the messages of a
+// batch and write them to the network when the batch is flushed.
+type batchWriter interface {
+	startBatch()
+	flushBatch(deadline time.Time) error
+}
+
+// writeBatch writes msg followed by the messages queued in out, up to the
+// configured batch size, and flushes them together. Without batching it
+// writes msg alone.
+func (conn *connection) writeBatch(msg *operationMessage, out <-chan *operationMessage) (err error) {
+	// The messages stay queued until the batch is flushed.
+	n := 1
+	defer func() {
+		conn.queued.Add(-int64(n))
+	}()
+
+	if conn.batch.maxSize <= 1 {
+		return conn.write(msg)
+	}
+
+	if bw, ok := conn.ws.(batchWriter); ok {
+		bw.startBatch()
+		defer func() {
+			// Flush after a failed write as well, so that the messages
+			// written before it are not left in the batch. The batch may
+			// have waited up to maxLatency, so the write timeout starts now.
+			if ferr := bw.flushBatch(time.Now().Add(conn.writeTimeout)); err == nil {
+				err = ferr
+			}
+		}()
+	}
+
+	// Every message gets its own deadline, as the batch may wait for the
+	// next one for longer than the write timeout.
+	if err := conn.write(msg); err != nil {
+		return err
+	}
+
+	var timeout <-chan time.Time
+	if conn.batch.maxLatency > 0 {
+		timer := time.NewTimer(conn.batch.maxLatency)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for n < conn.batch.maxSize {
+		select {
+		case msg = <-out:
+		default:
+			if timeout == nil {
+				return nil
+			}
+
+			select {
+			case msg = <-out:
+			case <-timeout:
+				return nil
+			}
+		}
+		n++
+
+		if err := conn.write(msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maxRetainedBatchBuffer is the largest batch buffer kept for the next batch.
+const maxRetainedBatchBuffer = 64 << 10
+
+// batchConn buffers the data messages written while a batch is open and
+// writes them to the network with a single Write when the batch is flushed.
+// Other writes, such as the pong and close frames that gorilla writes from
+// other goroutines, are not held back: they are written right away, after
+// the frames buffered so far to keep the order of frames.
+type batchConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	batching  bool
+	buffering bool // a data message of the batch is being written
+	buf       []byte
+}
+
+func (c *batchConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.batching && c.buffering {
+		c.buf = append(c.buf, p...)
+		return len(p), nil
+	}
+
+	if len(c.buf) == 0 {
+		return c.Conn.Write(p)
+	}
+
+	c.buf = append(c.buf, p...)
+	if err := c.writeBuffer(); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// buffer runs write, which writes a data message, buffering what it writes
+// while a batch is open. Frames written by other goroutines in the
+// meantime, between the frames of a fragmented message, are buffered with
+// it.
+func (c *batchConn) buffer(write func() error) error {
+	c.mu.Lock()
+	c.buffering = true
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.buffering = false
+		c.mu.Unlock()
+	}()
+
+	return write()
+}
+
+func (c *batchConn) start() {
+	c.mu.Lock()
+	c.batching = true
+	c.mu.Unlock()
+}
+
+// flush writes the batch with deadline and closes it.
+func (c *batchConn) flush(deadline time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.batching = false
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	if err := c.Conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	return c.writeBuffer()
+}
+
+// writeBuffer writes and empties the buffer. c.mu must be held.
+func (c *batchConn) writeBuffer() error {
+	buf := c.buf
+	c.buf = c.buf[:0]
+	if cap(c.buf) > maxRetainedBatchBuffer {
+		c.buf = nil
+	}
+
+	_, err := c.Conn.Write(buf)
+	return err
+}
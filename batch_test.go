@@ -0,0 +1,292 @@
+package graphqlws
+
+import (
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// batchRecorder records the number of messages written in each batch.
+type batchRecorder struct {
+	*mockConnection
+
+	mu      sync.Mutex
+	pending int
+	batches []int
+}
+
+func (r *batchRecorder) WriteMessage(typ MessageType, data []byte) error {
+	r.mu.Lock()
+	r.pending++
+	r.mu.Unlock()
+
+	return r.mockConnection.WriteMessage(typ, data)
+}
+
+func (r *batchRecorder) startBatch() {
+	r.mu.Lock()
+	r.pending = 0
+	r.mu.Unlock()
+}
+
+func (r *batchRecorder) flushBatch(deadline time.Time) error {
+	r.mu.Lock()
+	r.batches = append(r.batches, r.pending)
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *batchRecorder) getBatches() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]int(nil), r.batches...)
+}
+
+func TestWriteBatching(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		batch       batchOptions
+		wantBatches bool
+	}{
+		"batches queued messages": {
+			batch:       batchOptions{maxSize: 4, maxLatency: 50 * time.Millisecond},
+			wantBatches: true,
+		},
+		"without latency": {
+			batch:       batchOptions{maxSize: 4},
+			wantBatches: true,
+		},
+		"disabled": {
+			batch: batchOptions{maxSize: 1, maxLatency: time.Second},
+		},
+	}
+
+	for name, tt := range testTable {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			const events = 10
+
+			h := setupTest(t)
+			h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+				c := make(chan any, events)
+				for i := range events {
+					c <- map[string]int{"n": i}
+				}
+				close(c)
+				return c, nil
+			}
+
+			conn := &batchRecorder{mockConnection: h.conn}
+			go connectTransport(context.Background(), conn, h.mockSvc, transportWriteBatching(tt.batch))
+
+			h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+			h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`)
+
+			requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+			for i := range events {
+				requireEqualJSON(t, fmt.Sprintf(`{"id":"1","type":"next","payload":{"n":%d}}`, i), requireMessage(t, h.conn), "")
+			}
+			requireMessageType(t, requireMessage(t, h.conn), "complete")
+			close(h.conn.in)
+			requireClosed(t, h.conn)
+
+			batches := conn.getBatches()
+			if !tt.wantBatches {
+				if len(batches) != 0 {
+					t.Fatalf("expected no batches, got %v", batches)
+				}
+				return
+			}
+
+			var total int
+			for _, n := range batches {
+				if n < 1 || n > tt.batch.maxSize {
+					t.Fatalf("batch size %d outside [1, %d]: %v", n, tt.batch.maxSize, batches)
+				}
+				total += n
+			}
+			if total != events+2 {
+				t.Fatalf("expected %d messages in batches, got %d: %v", events+2, total, batches)
+			}
+		})
+	}
+}
+
+func TestWriteBatchingLatencyAboveWriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	svc := &fakeTransportService{
+		subscribeFn: func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any, 1)
+			c <- map[string]int{"n": 1}
+			close(c)
+			return c, nil
+		},
+	}
+
+	// Batches wait longer for more messages than a write may take.
+	srv := httptest.NewServer(NewHandlerFunc(svc, nil, WithWriteBatching(8, 300*time.Millisecond), WithWriteTimeout(100*time.Millisecond)))
+	defer srv.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{ProtocolGraphQLTransportWS}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for _, msg := range []string{
+		`{"type":"connection_init"}`,
+		`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+	} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range []string{"connection_ack", "next", "complete"} {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", want, err)
+		}
+		requireMessageType(t, msg, want)
+	}
+}
+
+// recordConn records the writes that reach the network.
+type recordConn struct {
+	net.Conn
+	writes []string
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, string(p))
+	return len(p), nil
+}
+
+func (c *recordConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func TestBatchConn(t *testing.T) {
+	t.Parallel()
+
+	rec := &recordConn{}
+	c := &batchConn{Conn: rec}
+
+	write := func(p string) func() error {
+		return func() error {
+			_, err := c.Write([]byte(p))
+			return err
+		}
+	}
+
+	_ = c.buffer(write("a"))
+	c.start()
+	_ = c.buffer(write("b"))
+	_ = c.buffer(write("c"))
+	if len(rec.writes) != 1 {
+		t.Fatalf("expected batched writes to be buffered, got %q", rec.writes)
+	}
+
+	// Another goroutine's frame, such as a pong, is written right away,
+	// after the frames buffered before it.
+	_ = write("pong")()
+	_ = c.buffer(write("d"))
+
+	if err := c.flush(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.buffer(write("e"))
+
+	want := []string{"a", "bcpong", "d", "e"}
+	if fmt.Sprint(rec.writes) != fmt.Sprint(want) {
+		t.Fatalf("want writes %q, got %q", want, rec.writes)
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	payload := map[string]any{"data": map[string]any{"tick": map[string]any{"symbol": "GOPH", "price": 42.5}}}
+
+	modes := map[string][]Option{
+		"per message":            nil,
+		"batched":                {WithWriteBatching(64, 0)},
+		"batched with latency":   {WithWriteBatching(64, time.Millisecond)},
+		"batched and compressed": {WithWriteBatching(64, 0), WithCompression(flate.BestSpeed, 0)},
+	}
+	payloads := map[string]func() any{
+		"map":         func() any { return payload },
+		"pre-encoded": func() any { return NewPreEncoded(payload) },
+	}
+
+	for mode, opts := range modes {
+		for kind, next := range payloads {
+			b.Run(mode+"/"+kind, func(b *testing.B) {
+				benchmarkWrite(b, next, opts...)
+			})
+		}
+	}
+}
+
+func benchmarkWrite(b *testing.B, next func() any, opts ...Option) {
+	n := b.N
+	svc := &fakeTransportService{
+		subscribeFn: func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any)
+			go func() {
+				defer close(c)
+				for range n {
+					select {
+					case c <- next():
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return c, nil
+		},
+	}
+
+	srv := httptest.NewServer(NewHandlerFunc(svc, nil, opts...))
+	defer srv.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{ProtocolGraphQLTransportWS}, EnableCompression: true}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_init"}`)); err != nil {
+		b.Fatal(err)
+	}
+	if _, _, err := ws.ReadMessage(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","type":"subscribe","payload":{"query":"subscription { tick }"}}`)); err != nil {
+		b.Fatal(err)
+	}
+	for range n {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Command graphqlws-conformance checks a graphql-transport-ws server against
+// the protocol and prints a pass/fail report.
+//
+//	go run github.com/graph-gophers/graphql-transport-ws/cmd/graphqlws-conformance \
+//		-url ws://localhost:8080/graphql \
+//		-subscription 'subscription { ticks(count: 100) { number } }'
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/graphql-transport-ws/conformance"
+)
+
+type headerFlag http.Header
+
+func (h headerFlag) String() string { return "" }
+
+func (h headerFlag) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	if !ok {
+		return fmt.Errorf("header %q is not in Name: value form", v)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
+
+func main() {
+	var (
+		cfg         conformance.Config
+		initPayload string
+		header      = headerFlag{}
+	)
+
+	flag.StringVar(&cfg.URL, "url", "ws://localhost:8080/graphql", "WebSocket endpoint of the server under test")
+	flag.StringVar(&cfg.Subscription, "subscription", "", "long-running subscription document; cases needing an active operation are skipped without it")
+	flag.StringVar(&initPayload, "init-payload", "", "JSON object sent as the connection_init payload")
+	flag.DurationVar(&cfg.InitTimeout, "init-timeout", 3*time.Second, "server connection_init timeout; negative skips the check")
+	flag.DurationVar(&cfg.Timeout, "timeout", 2*time.Second, "time to wait for each server reply")
+	flag.Var(header, "header", "handshake header in Name: value form (repeatable)")
+	flag.Parse()
+
+	if initPayload != "" {
+		if err := json.Unmarshal([]byte(initPayload), &cfg.InitPayload); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -init-payload: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	cfg.Header = http.Header(header)
+
+	report := conformance.Run(context.Background(), cfg)
+	_, _ = report.WriteTo(os.Stdout)
+
+	if !report.Passed() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,164 @@
+package graphqlws
+
+import (
+	"encoding/json"
+)
+
+// Codec encodes the messages of a connection.
+//
+// Payloads are encoded separately from the message around them, so that a
+// payload is encoded once and embedded as is. The transport decodes payloads
+// into Go types using json struct tags, json.RawMessage fields and untyped
+// maps, and encodes values such as graphql-go responses that rely on
+// json.Marshaler; a Codec must treat them the way encoding/json does.
+type Codec interface {
+	// Name identifies the encoding. Payloads retained by the replay buffer
+	// and by session resumption are only served to connections using a
+	// codec with the same name.
+	Name() string
+
+	// MessageType is the frame type messages are sent in.
+	MessageType() MessageType
+
+	// Marshal encodes a payload.
+	Marshal(v any) ([]byte, error)
+
+	// Unmarshal decodes a payload.
+	Unmarshal(data []byte, v any) error
+
+	// EncodeMessage encodes a message. payload was produced by Marshal, or
+	// received from the peer, and may be nil. id is empty for messages that
+	// do not belong to an operation.
+	EncodeMessage(id string, typ string, payload []byte) ([]byte, error)
+
+	// DecodeMessage decodes a message received from the peer. The returned
+	// payload is decoded separately with Unmarshal.
+	DecodeMessage(data []byte) (id string, typ string, payload []byte, err error)
+}
+
+// JSONCodec is the codec of the graphql-transport-ws subprotocol. By default
+// it uses encoding/json; set MarshalFunc and UnmarshalFunc to use a faster
+// implementation that is compatible with it.
+type JSONCodec struct {
+	MarshalFunc   func(v any) ([]byte, error)
+	UnmarshalFunc func(data []byte, v any) error
+}
+
+// Name returns "json".
+func (c JSONCodec) Name() string {
+	return "json"
+}
+
+// MessageType returns TextMessage.
+func (c JSONCodec) MessageType() MessageType {
+	return TextMessage
+}
+
+// Marshal encodes v as JSON. A json.RawMessage holding valid JSON is
+// returned as is; an invalid one is rejected like encoding/json does.
+func (c JSONCodec) Marshal(v any) ([]byte, error) {
+	if raw, ok := v.(json.RawMessage); ok && json.Valid(raw) {
+		return raw, nil
+	}
+	if c.MarshalFunc != nil {
+		return c.MarshalFunc(v)
+	}
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v.
+func (c JSONCodec) Unmarshal(data []byte, v any) error {
+	if c.UnmarshalFunc != nil {
+		return c.UnmarshalFunc(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// EncodeMessage writes payload into the message verbatim, without decoding
+// or validating it again.
+func (c JSONCodec) EncodeMessage(id string, typ string, payload []byte) ([]byte, error) {
+	b := make([]byte, 0, len(id)+len(typ)+len(payload)+32)
+	b = append(b, '{')
+
+	if id != "" {
+		quoted, err := json.Marshal(id)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, `"id":`...)
+		b = append(b, quoted...)
+		b = append(b, ',')
+	}
+
+	quoted, err := json.Marshal(typ)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, `"type":`...)
+	b = append(b, quoted...)
+
+	if len(payload) > 0 {
+		b = append(b, `,"payload":`...)
+		b = append(b, payload...)
+	}
+
+	return append(b, '}'), nil
+}
+
+// DecodeMessage decodes a JSON message.
+func (c JSONCodec) DecodeMessage(data []byte) (string, string, []byte, error) {
+	var msg struct {
+		ID      string          `json:"id"`
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := c.Unmarshal(data, &msg); err != nil {
+		return "", "", nil, err
+	}
+
+	return msg.ID, msg.Type, msg.Payload, nil
+}
+
+type subprotocol struct {
+	name  string
+	codec Codec
+}
+
+// WithCodec replaces the codec of the graphql-transport-ws subprotocol, for
+// example with a JSONCodec using a faster JSON implementation. It is also the
+// codec used by ServeConn.
+func WithCodec(c Codec) Option {
+	return optionFunc(func(o *options) {
+		o.codec = c
+	})
+}
+
+// WithSubprotocol lets the handler accept the subprotocol name in addition to
+// ProtocolGraphQLTransportWS. Connections that negotiate it exchange the
+// messages of graphql-transport-ws encoded with c, for example MessagePack in
+// binary frames. When a client offers several, subprotocols added with
+// WithSubprotocol are preferred in the order they are added, then
+// ProtocolGraphQLTransportWS.
+func WithSubprotocol(name string, c Codec) Option {
+	return optionFunc(func(o *options) {
+		o.subprotocols = append(o.subprotocols, subprotocol{name: name, codec: c})
+	})
+}
+
+// subprotocolCodec returns the codec of a negotiated subprotocol.
+func (o *options) subprotocolCodec(name string) (Codec, bool) {
+	if name == ProtocolGraphQLTransportWS {
+		if o.codec != nil {
+			return o.codec, true
+		}
+		return JSONCodec{}, true
+	}
+
+	for _, p := range o.subprotocols {
+		if p.name == name {
+			return p.codec, true
+		}
+	}
+
+	return nil, false
+}
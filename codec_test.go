@@ -0,0 +1,123 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONCodec(t *testing.T) {
+	t.Parallel()
+
+	t.Run("encode message", func(t *testing.T) {
+		t.Parallel()
+
+		testTable := map[string]struct {
+			id      string
+			typ     string
+			payload json.RawMessage
+			want    string
+		}{
+			"without id and payload": {
+				typ:  "connection_ack",
+				want: `{"type":"connection_ack"}`,
+			},
+			"with payload": {
+				id:      "1",
+				typ:     "next",
+				payload: json.RawMessage(`{"data":{"n":1}}`),
+				want:    `{"id":"1","type":"next","payload":{"data":{"n":1}}}`,
+			},
+			"escapes id": {
+				id:   "\"quoted\"\n",
+				typ:  "complete",
+				want: `{"id":"\"quoted\"\n","type":"complete"}`,
+			},
+		}
+
+		for name, tt := range testTable {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				got, err := JSONCodec{}.EncodeMessage(tt.id, tt.typ, tt.payload)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(got) != tt.want {
+					t.Fatalf("want %s, got %s", tt.want, got)
+				}
+
+				id, typ, payload, err := JSONCodec{}.DecodeMessage(got)
+				if err != nil {
+					t.Fatalf("failed to decode encoded message: %v", err)
+				}
+				if id != tt.id || typ != tt.typ || string(payload) != string(tt.payload) {
+					t.Fatalf("round trip mismatch: %q %q %s", id, typ, payload)
+				}
+			})
+		}
+	})
+
+	t.Run("marshal raw message", func(t *testing.T) {
+		t.Parallel()
+
+		testTable := map[string]struct {
+			raw     json.RawMessage
+			want    string
+			wantErr bool
+		}{
+			"valid":   {raw: json.RawMessage(`{"data":{"n":1}}`), want: `{"data":{"n":1}}`},
+			"nil":     {raw: nil, want: `null`},
+			"empty":   {raw: json.RawMessage{}, wantErr: true},
+			"invalid": {raw: json.RawMessage(`{"data":`), wantErr: true},
+		}
+
+		for name, tt := range testTable {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				got, err := JSONCodec{}.Marshal(tt.raw)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("want error %v, got %v", tt.wantErr, err)
+				}
+				if err == nil && string(got) != tt.want {
+					t.Fatalf("want %s, got %s", tt.want, got)
+				}
+			})
+		}
+	})
+
+	t.Run("custom marshal func", func(t *testing.T) {
+		t.Parallel()
+
+		var marshaled int
+		codec := JSONCodec{
+			MarshalFunc: func(v any) ([]byte, error) {
+				marshaled++
+				return json.Marshal(v)
+			},
+		}
+
+		h := setupTest(t)
+		h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any, 1)
+			c <- map[string]any{"data": map[string]int{"n": 1}}
+			close(c)
+			return c, nil
+		}
+
+		go connectTransport(context.Background(), h.conn, h.mockSvc, transportCodec(codec))
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+		h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`)
+
+		requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+		requireEqualJSON(t, `{"id":"1","type":"next","payload":{"data":{"n":1}}}`, requireMessage(t, h.conn), "")
+		requireMessageType(t, requireMessage(t, h.conn), "complete")
+		close(h.conn.in)
+
+		if marshaled != 1 {
+			t.Fatalf("expected the next payload to be marshaled once with MarshalFunc, got %d calls", marshaled)
+		}
+	})
+}
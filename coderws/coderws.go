@@ -0,0 +1,174 @@
+// Package coderws adapts github.com/coder/websocket (formerly
+// nhooyr.io/websocket) connections to graphqlws.Conn.
+//
+//	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+//		Subprotocols: []string{graphqlws.ProtocolGraphQLTransportWS},
+//	})
+//	if err != nil {
+//		return
+//	}
+//	graphqlws.ServeConn(ctx, coderws.New(c), sub)
+package coderws
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+
+	graphqlws "github.com/graph-gophers/graphql-transport-ws"
+)
+
+// New adapts c to graphqlws.Conn.
+//
+// coder/websocket closes the connection when the context of a read expires.
+// The adapter instead keeps a read running in the background and reports a
+// timeout once the read deadline passes, so that the transport can still send
+// a close frame to an idle client.
+func New(c *websocket.Conn) graphqlws.Conn {
+	return &conn{c: c, deadlineSet: make(chan struct{})}
+}
+
+type readResult struct {
+	typ  websocket.MessageType
+	data []byte
+	err  error
+}
+
+type conn struct {
+	c *websocket.Conn
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	reading       chan readResult // non-nil while a read is in flight
+	deadlineSet   chan struct{}   // closed when the read deadline changes
+}
+
+func (c *conn) ReadMessage() (graphqlws.MessageType, []byte, error) {
+	c.mu.Lock()
+	if c.reading == nil {
+		c.reading = make(chan readResult, 1)
+		go func(res chan<- readResult) {
+			typ, data, err := c.c.Read(context.Background())
+			res <- readResult{typ: typ, data: data, err: err}
+		}(c.reading)
+	}
+	reading := c.reading
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		deadline, deadlineSet := c.readDeadline, c.deadlineSet
+		c.mu.Unlock()
+
+		res, ok, timedOut := wait(reading, deadline, deadlineSet)
+		if timedOut {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+		if !ok {
+			continue
+		}
+
+		c.mu.Lock()
+		c.reading = nil
+		c.mu.Unlock()
+
+		var closeErr websocket.CloseError
+		if errors.As(res.err, &closeErr) {
+			return 0, nil, &graphqlws.CloseError{Code: int(closeErr.Code), Reason: closeErr.Reason}
+		}
+
+		return messageType(res.typ), res.data, res.err
+	}
+}
+
+// wait waits for the read in flight until deadline passes or deadlineSet is
+// closed. ok reports whether the read finished.
+func wait(reading <-chan readResult, deadline time.Time, deadlineSet <-chan struct{}) (res readResult, ok bool, timedOut bool) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case res = <-reading:
+		return res, true, false
+	case <-timeout:
+		return res, false, true
+	case <-deadlineSet:
+		return res, false, false
+	}
+}
+
+func (c *conn) WriteMessage(typ graphqlws.MessageType, data []byte) error {
+	ctx, cancel := c.writeContext()
+	defer cancel()
+
+	wsType := websocket.MessageText
+	if typ == graphqlws.BinaryMessage {
+		wsType = websocket.MessageBinary
+	}
+
+	return c.c.Write(ctx, wsType, data)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	close(c.deadlineSet)
+	c.deadlineSet = make(chan struct{})
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *conn) SetReadLimit(limit int64) {
+	c.c.SetReadLimit(limit)
+}
+
+// Subprotocol returns the negotiated subprotocol, which a
+// graphqlws.DebugRegistry shows for the connection.
+func (c *conn) Subprotocol() string {
+	return c.c.Subprotocol()
+}
+
+// Close performs the close handshake, which waits up to 5 seconds for the
+// peer to answer the close frame.
+func (c *conn) Close(code int, reason string) error {
+	if code == 0 {
+		return c.c.CloseNow()
+	}
+
+	return c.c.Close(websocket.StatusCode(code), reason)
+}
+
+func (c *conn) writeContext() (context.Context, context.CancelFunc) {
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+
+	if deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithDeadline(context.Background(), deadline)
+}
+
+func messageType(typ websocket.MessageType) graphqlws.MessageType {
+	if typ == websocket.MessageBinary {
+		return graphqlws.BinaryMessage
+	}
+	return graphqlws.TextMessage
+}
@@ -0,0 +1,83 @@
+package coderws_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	graphqlws "github.com/graph-gophers/graphql-transport-ws"
+	"github.com/graph-gophers/graphql-transport-ws/coderws"
+	"github.com/graph-gophers/graphql-transport-ws/conformance"
+)
+
+// idleSubscriber starts operations that never produce a value.
+type idleSubscriber struct{}
+
+func (idleSubscriber) Subscribe(ctx context.Context, document string, operation string, variables map[string]any) (<-chan any, error) {
+	return make(chan any), nil
+}
+
+func TestConformance(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+			Subprotocols: []string{graphqlws.ProtocolGraphQLTransportWS},
+		})
+		if err != nil {
+			return
+		}
+
+		graphqlws.ServeConn(context.Background(), coderws.New(c), idleSubscriber{}, graphqlws.WithWriteTimeout(100*time.Millisecond))
+	}))
+	t.Cleanup(srv.Close)
+
+	conformance.RunTests(t, conformance.Config{
+		URL:          "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Subscription: "subscription { ticks }",
+		InitTimeout:  100 * time.Millisecond,
+		Timeout:      time.Second,
+	})
+}
+
+func TestReadIdleTimeoutSendsCloseFrame(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+			Subprotocols: []string{graphqlws.ProtocolGraphQLTransportWS},
+		})
+		if err != nil {
+			return
+		}
+
+		graphqlws.ServeConn(r.Context(), coderws.New(c), idleSubscriber{}, graphqlws.WithReadIdleTimeout(50*time.Millisecond))
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, _, err := websocket.Dial(ctx, srv.URL, &websocket.DialOptions{
+		Subprotocols: []string{graphqlws.ProtocolGraphQLTransportWS},
+	})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.CloseNow()
+
+	if err := c.Write(ctx, websocket.MessageText, []byte(`{"type":"connection_init"}`)); err != nil {
+		t.Fatalf("write connection_init: %v", err)
+	}
+	if _, data, err := c.Read(ctx); err != nil || !strings.Contains(string(data), "connection_ack") {
+		t.Fatalf("expected connection_ack, got %q (%v)", data, err)
+	}
+
+	_, _, err = c.Read(ctx)
+	if got := websocket.CloseStatus(err); got != websocket.StatusNormalClosure {
+		t.Fatalf("expected close %d after idle timeout, got %v", websocket.StatusNormalClosure, err)
+	}
+}
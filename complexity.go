@@ -0,0 +1,237 @@
+package graphqlws
+
+import (
+	"math"
+
+	"github.com/graph-gophers/graphql-go/ast"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// errorCodeOperationLimit is the extensions.code of the errors rejecting
+// operations over the OperationLimits.
+const errorCodeOperationLimit = "OPERATION_LIMIT_EXCEEDED"
+
+// OperationLimits bounds the size and cost of the operations clients
+// subscribe to. Zero fields impose no limit.
+type OperationLimits struct {
+	// MaxDepth is the maximum nesting depth of fields. Top-level fields
+	// have a depth of 1.
+	MaxDepth int
+
+	// MaxFields is the maximum number of fields an operation selects.
+	// The fields of a fragment are counted every time it is spread.
+	MaxFields int
+
+	// MaxCost is the maximum sum of the costs of the fields an operation
+	// selects.
+	MaxCost int
+
+	// FieldCost returns the cost of a field. If nil, a field costs the
+	// weight of the @cost(weight: Int!) directive on its definition in
+	// the schema, or 1.
+	FieldCost func(Field) int
+}
+
+// Field is a field selected by an operation.
+type Field struct {
+	// Type is the name of the type the field is selected on. It is empty
+	// if the Subscriber does not expose a graphql-go schema, see
+	// WithSchemaValidation.
+	Type string
+
+	// Name is the name of the field.
+	Name string
+
+	// Arguments are the arguments of the field, with variables replaced
+	// by their values. Literals are decoded as encoding/json decodes the
+	// equivalent JSON.
+	Arguments map[string]any
+}
+
+// WithOperationLimits analyses subscribe documents before they are passed
+// to the Subscriber and rejects operations over limits with an error
+// message with extensions.code OPERATION_LIMIT_EXCEEDED.
+func WithOperationLimits(limits OperationLimits) Option {
+	return optionFunc(func(o *options) {
+		o.operationLimits = &limits
+	})
+}
+
+// operationCost is the size and cost of a selection set.
+type operationCost struct {
+	depth  int
+	fields int
+	cost   int
+}
+
+func (c *operationCost) merge(other operationCost) {
+	c.depth = max(c.depth, other.depth)
+	c.fields = addCost(c.fields, other.fields)
+	c.cost = addCost(c.cost, other.cost)
+}
+
+// addCost adds non-negative costs, saturating at math.MaxInt.
+func addCost(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// check returns errors for the limits op exceeds.
+func (l *OperationLimits) check(schema *ast.Schema, doc *document, op *operationDefinition, vars map[string]any) []*gqlerrors.QueryError {
+	a := &costAnalysis{
+		limits:    l,
+		schema:    schema,
+		doc:       doc,
+		vars:      vars,
+		fragments: make(map[string]*operationCost),
+	}
+
+	var root ast.NamedType
+	if schema != nil {
+		root = schema.RootOperationTypes[op.typ]
+	}
+	c := a.selectionSet(root, op.selections)
+
+	var errs []*gqlerrors.QueryError
+	exceeded := func(format string, value, limit int) {
+		if limit > 0 && value > limit {
+			err := gqlerrors.Errorf(format, value, limit)
+			err.Locations = []gqlerrors.Location{op.loc}
+			err.Extensions = map[string]any{"code": errorCodeOperationLimit}
+			errs = append(errs, err)
+		}
+	}
+	exceeded("operation has depth %d, exceeding the limit of %d", c.depth, l.MaxDepth)
+	exceeded("operation selects %d fields, exceeding the limit of %d", c.fields, l.MaxFields)
+	exceeded("operation has cost %d, exceeding the limit of %d", c.cost, l.MaxCost)
+
+	return errs
+}
+
+// costAnalysis computes the cost of an operation. The cost of each fragment
+// is computed once, so that documents spreading fragments repeatedly cannot
+// make the analysis itself expensive.
+type costAnalysis struct {
+	limits *OperationLimits
+	schema *ast.Schema // nil if the schema is unknown
+	doc    *document
+	vars   map[string]any
+
+	fragments map[string]*operationCost // nil while a fragment is analysed
+}
+
+func (a *costAnalysis) selectionSet(typ ast.NamedType, selections []*selection) operationCost {
+	var c operationCost
+
+	for _, s := range selections {
+		switch {
+		case s.field != "":
+			def := fieldDefinition(typ, s.field)
+
+			var fieldType ast.NamedType
+			if def != nil {
+				fieldType = namedType(def.Type)
+			}
+
+			sub := a.selectionSet(fieldType, s.selections)
+			sub.depth++
+			sub.fields = addCost(sub.fields, 1)
+			sub.cost = addCost(sub.cost, a.fieldCost(typ, s, def))
+			c.merge(sub)
+
+		case s.fragment != "":
+			c.merge(a.fragment(s.fragment))
+
+		default:
+			fragmentType := typ
+			if s.typeCondition != "" {
+				fragmentType = a.namedType(s.typeCondition)
+			}
+			c.merge(a.selectionSet(fragmentType, s.selections))
+		}
+	}
+
+	return c
+}
+
+func (a *costAnalysis) fragment(name string) operationCost {
+	if c, ok := a.fragments[name]; ok {
+		if c == nil {
+			return operationCost{} // a cycle, which validation rejects
+		}
+		return *c
+	}
+
+	f, ok := a.doc.fragments[name]
+	if !ok {
+		return operationCost{}
+	}
+
+	a.fragments[name] = nil
+	c := a.selectionSet(a.namedType(f.typeCondition), f.selections)
+	a.fragments[name] = &c
+
+	return c
+}
+
+func (a *costAnalysis) fieldCost(typ ast.NamedType, s *selection, def *ast.FieldDefinition) int {
+	if a.limits.FieldCost != nil {
+		f := Field{Name: s.field}
+		if typ != nil {
+			f.Type = typ.TypeName()
+		}
+		if s.arguments != nil {
+			f.Arguments = resolveVariables(s.arguments, a.vars).(map[string]any)
+		}
+		return max(a.limits.FieldCost(f), 0)
+	}
+
+	if def != nil {
+		if d := def.Directives.Get("cost"); d != nil {
+			if w, ok := d.Arguments.Get("weight"); ok {
+				if w, ok := w.Deserialize(nil).(int32); ok {
+					return max(int(w), 0)
+				}
+			}
+		}
+	}
+
+	return 1
+}
+
+func (a *costAnalysis) namedType(name string) ast.NamedType {
+	if a.schema == nil {
+		return nil
+	}
+	return a.schema.Types[name]
+}
+
+// fieldDefinition returns the definition of the field name of typ, or nil.
+func fieldDefinition(typ ast.NamedType, name string) *ast.FieldDefinition {
+	switch t := typ.(type) {
+	case *ast.ObjectTypeDefinition:
+		return t.Fields.Get(name)
+	case *ast.InterfaceTypeDefinition:
+		return t.Fields.Get(name)
+	}
+	return nil
+}
+
+// namedType returns the named type of t, which may be a list or non-null
+// type.
+func namedType(t ast.Type) ast.NamedType {
+	for {
+		switch u := t.(type) {
+		case *ast.List:
+			t = u.OfType
+		case *ast.NonNull:
+			t = u.OfType
+		case ast.NamedType:
+			return u
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const costSchema = `
+	directive @cost(weight: Int!) on FIELD_DEFINITION
+
+	schema {
+		query: Query
+		subscription: Subscription
+	}
+
+	type Query {
+		hello: String!
+	}
+
+	type Subscription {
+		posts: [Post!]!
+	}
+
+	interface Node {
+		id: ID!
+	}
+
+	type Post implements Node {
+		id: ID!
+		title: String!
+		author: User! @cost(weight: 5)
+	}
+
+	type User implements Node {
+		id: ID!
+		name: String!
+		posts(first: Int!): [Post!]! @cost(weight: 10)
+	}
+`
+
+type costResolver struct{}
+
+func (*costResolver) Hello() string { return "hello" }
+
+func (*costResolver) Posts() <-chan []*costPost { return nil }
+
+type costPost struct{}
+
+func (*costPost) ID() graphql.ID    { return "" }
+func (*costPost) Title() string     { return "" }
+func (*costPost) Author() *costUser { return nil }
+
+type costUser struct{}
+
+func (*costUser) ID() graphql.ID                          { return "" }
+func (*costUser) Name() string                            { return "" }
+func (*costUser) Posts(struct{ First int32 }) []*costPost { return nil }
+
+func TestOperationLimits(t *testing.T) {
+	t.Parallel()
+
+	schema := graphql.MustParseSchema(costSchema, &costResolver{})
+
+	// weightByFirst makes posts cost their first argument.
+	weightByFirst := func(f Field) int {
+		if f.Type == "User" && f.Name == "posts" {
+			first, _ := f.Arguments["first"].(float64)
+			return int(first)
+		}
+		return 1
+	}
+
+	testTable := map[string]struct {
+		query  string
+		vars   map[string]any
+		limits OperationLimits
+		want   []string
+	}{
+		"within limits": {
+			query:  `subscription { posts { title author { name } } }`,
+			limits: OperationLimits{MaxDepth: 3, MaxFields: 4, MaxCost: 8},
+		},
+		"depth": {
+			query:  `subscription { posts { author { posts(first: 1) { title } } } }`,
+			limits: OperationLimits{MaxDepth: 3},
+			want:   []string{"operation has depth 4, exceeding the limit of 3"},
+		},
+		"fields through fragments": {
+			query: `subscription { posts { ...P ...P } }
+				fragment P on Post { id title }`,
+			limits: OperationLimits{MaxFields: 4},
+			want:   []string{"operation selects 5 fields, exceeding the limit of 4"},
+		},
+		"cost directive": {
+			query:  `subscription { posts { author { posts(first: 1) { id } } } }`,
+			limits: OperationLimits{MaxCost: 16},
+			want:   []string{"operation has cost 17, exceeding the limit of 16"},
+		},
+		"inline fragment types": {
+			query:  `subscription { posts { author { ... on Node { id } ... on User { posts(first: 1) { id } } } } }`,
+			limits: OperationLimits{MaxCost: 16},
+			want:   []string{"operation has cost 18, exceeding the limit of 16"},
+		},
+		"cost function with variables": {
+			query:  `subscription S($n: Int!) { posts { author { posts(first: $n) { id } } } }`,
+			vars:   map[string]any{"n": float64(100)},
+			limits: OperationLimits{MaxCost: 50, FieldCost: weightByFirst},
+			want:   []string{"operation has cost 103, exceeding the limit of 50"},
+		},
+		"several limits": {
+			query:  `subscription { posts { author { name } } }`,
+			limits: OperationLimits{MaxDepth: 2, MaxFields: 2, MaxCost: 100},
+			want: []string{
+				"operation has depth 3, exceeding the limit of 2",
+				"operation selects 3 fields, exceeding the limit of 2",
+			},
+		},
+	}
+
+	for name, tt := range testTable {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			doc, err := parseDocument(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			errs := tt.limits.check(schema.ASTSchema(), doc, doc.operations[0], tt.vars)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("want errors %q, got %v", tt.want, errs)
+			}
+			for i, err := range errs {
+				if err.Message != tt.want[i] || err.Extensions["code"] != errorCodeOperationLimit {
+					t.Fatalf("want error %q, got %+v", tt.want[i], err)
+				}
+			}
+		})
+	}
+}
+
+func TestOperationLimitsFragmentBomb(t *testing.T) {
+	t.Parallel()
+
+	// Each fragment spreads the previous one twice, selecting 2^40 fields.
+	query := "subscription { ...F40 }\nfragment F0 on Subscription { a }\n"
+	for i := 1; i <= 40; i++ {
+		query += "fragment F" + strconv.Itoa(i) + " on Subscription { ...F" + strconv.Itoa(i-1) + " ...F" + strconv.Itoa(i-1) + " }\n"
+	}
+
+	doc, err := parseDocument(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := OperationLimits{MaxFields: 1000}
+	errs := limits.check(nil, doc, doc.operations[0], nil)
+	if len(errs) != 1 || errs[0].Message != "operation selects 1099511627776 fields, exceeding the limit of 1000" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+func TestOperationLimitsTransport(t *testing.T) {
+	t.Parallel()
+
+	h := setupTest(t)
+	v := &documentValidator{limits: &OperationLimits{MaxDepth: 2}}
+	go connectTransport(context.Background(), h.conn, h.mockSvc, transportValidation(v))
+	defer close(h.conn.in)
+
+	h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+	h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription {\n  a { b { c } }\n}"}}`)
+	requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+	requireEqualJSON(t, `{"id":"1","type":"error","payload":[{"message":"operation has depth 3, exceeding the limit of 2","locations":[{"line":1,"column":1}],"extensions":{"code":"OPERATION_LIMIT_EXCEEDED"}}]}`, requireMessage(t, h.conn), "")
+	if calls := h.mockSvc.getCalls(); len(calls) != 0 {
+		t.Fatalf("expected the operation not to be subscribed, got %d calls", len(calls))
+	}
+}
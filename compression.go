@@ -0,0 +1,61 @@
+package graphqlws
+
+import (
+	"compress/flate"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+type compressionOptions struct {
+	level   int
+	minSize int
+}
+
+// WithCompression enables per-message compression (permessage-deflate,
+// RFC 7692) for clients that offer it. Messages smaller than minSize bytes
+// are sent uncompressed, as compressing them costs more CPU than it saves
+// bandwidth.
+//
+// level is a compress/flate level from flate.HuffmanOnly to
+// flate.BestCompression; other values select flate.DefaultCompression.
+//
+// Compression keeps no context between messages, so each message is
+// compressed on its own. Use WithMetricsHooks to observe the achieved ratio.
+func WithCompression(level int, minSize int) Option {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+
+	return optionFunc(func(o *options) {
+		o.compression = &compressionOptions{level: level, minSize: max(minSize, 0)}
+	})
+}
+
+// offersCompression reports whether the client offered permessage-deflate in
+// its handshake, in which case the upgrader accepts it.
+func offersCompression(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-Websocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// countingConn counts the bytes written to the network.
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,217 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const subprotocol = "graphql-transport-ws"
+
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type client struct {
+	ws      *websocket.Conn
+	timeout time.Duration
+}
+
+func dial(ctx context.Context, cfg Config) (*client, error) {
+	dialer := websocket.Dialer{
+		Subprotocols:     []string{subprotocol},
+		HandshakeTimeout: cfg.Timeout,
+	}
+
+	ws, _, err := dialer.DialContext(ctx, cfg.URL, cfg.Header)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+
+	if ws.Subprotocol() != subprotocol {
+		ws.Close()
+		return nil, fmt.Errorf("server selected subprotocol %q, want %q", ws.Subprotocol(), subprotocol)
+	}
+
+	return &client{ws: ws, timeout: cfg.Timeout}, nil
+}
+
+// initialised dials and completes the connection_init handshake.
+func initialised(ctx context.Context, cfg Config) (*client, error) {
+	c, err := dial(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload json.RawMessage
+	if cfg.InitPayload != nil {
+		if payload, err = json.Marshal(cfg.InitPayload); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+
+	if err := c.send(message{Type: "connection_init", Payload: payload}); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	msg, err := c.read()
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("waiting for connection_ack: %w", err)
+	}
+	if msg.Type != "connection_ack" {
+		c.close()
+		return nil, fmt.Errorf("expected connection_ack, got %s", msg.Type)
+	}
+
+	return c, nil
+}
+
+func (c *client) close() {
+	_ = c.ws.Close()
+}
+
+func (c *client) send(msg message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(b)
+}
+
+func (c *client) sendRaw(b []byte) error {
+	if err := c.ws.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if err := c.ws.WriteMessage(websocket.TextMessage, b); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+func (c *client) subscribe(id string, query string, variables map[string]any) error {
+	payload, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+	return c.send(message{ID: id, Type: "subscribe", Payload: payload})
+}
+
+func (c *client) ping(nonce string) error {
+	payload, _ := json.Marshal(map[string]string{"nonce": nonce})
+	return c.send(message{Type: "ping", Payload: payload})
+}
+
+// roundTrip sends a ping and expects the very next message to be a pong
+// echoing its payload.
+func (c *client) roundTrip(nonce string) error {
+	if err := c.ping(nonce); err != nil {
+		return err
+	}
+
+	msg, err := c.read()
+	if err != nil {
+		return fmt.Errorf("waiting for pong: %w", err)
+	}
+	if msg.Type != "pong" {
+		return fmt.Errorf("expected pong, got %s", msg.Type)
+	}
+
+	want, _ := json.Marshal(map[string]string{"nonce": nonce})
+	if !jsonEqual(want, msg.Payload) {
+		return fmt.Errorf("pong payload %s does not echo ping payload %s", msg.Payload, want)
+	}
+
+	return nil
+}
+
+func (c *client) read() (message, error) {
+	return c.readWithin(c.timeout)
+}
+
+func (c *client) readWithin(d time.Duration) (message, error) {
+	if err := c.ws.SetReadDeadline(time.Now().Add(d)); err != nil {
+		return message{}, err
+	}
+
+	_, data, err := c.ws.ReadMessage()
+	if err != nil {
+		return message{}, err
+	}
+
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return message{}, fmt.Errorf("server sent invalid message %q: %w", data, err)
+	}
+
+	return msg, nil
+}
+
+// readUntil reads messages until one satisfies match.
+func (c *client) readUntil(match func(message) bool) (message, error) {
+	deadline := time.Now().Add(c.timeout)
+	for {
+		msg, err := c.readWithin(time.Until(deadline))
+		if err != nil {
+			return message{}, err
+		}
+		if match(msg) {
+			return msg, nil
+		}
+	}
+}
+
+// readQuiet returns the next message if one arrives within d, or nil.
+func (c *client) readQuiet(d time.Duration) (*message, error) {
+	msg, err := c.readWithin(d)
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// expectClose reads until the server closes the socket and checks the code.
+// Data messages sent before the close are ignored.
+func (c *client) expectClose(code int) error {
+	deadline := time.Now().Add(c.timeout)
+	for {
+		_, err := c.readWithin(time.Until(deadline))
+		if err == nil {
+			continue
+		}
+
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			return fmt.Errorf("expected close %d, got %w", code, err)
+		}
+		if closeErr.Code != code {
+			return fmt.Errorf("expected close %d, got close %d (%s)", code, closeErr.Code, closeErr.Text)
+		}
+
+		return nil
+	}
+}
+
+func jsonEqual(a, b []byte) bool {
+	var bufA, bufB bytes.Buffer
+	if json.Compact(&bufA, a) != nil || json.Compact(&bufB, b) != nil {
+		return false
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes())
+}
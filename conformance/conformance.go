@@ -0,0 +1,386 @@
+// Package conformance checks that a server implements the graphql-transport-ws
+// subprotocol as specified in
+// https://github.com/graphql/graphql-over-http/blob/main/rfcs/GraphQLOverWebSocket.md.
+//
+// The suite talks to a server over a real WebSocket URL, so it can target any
+// implementation, not only servers built with graphqlws:
+//
+//	report := conformance.Run(ctx, conformance.Config{
+//		URL:          "ws://localhost:8080/graphql",
+//		Subscription: "subscription { ticks { number } }",
+//	})
+//	report.WriteTo(os.Stdout)
+//
+// Use RunTests to run the suite as subtests of a Go test.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Close codes defined by the protocol.
+const (
+	CloseBadRequest                = 4400
+	CloseUnauthorized              = 4401
+	CloseConnectionInitTimeout     = 4408
+	CloseSubscriberAlreadyExists   = 4409
+	CloseTooManyInitialisationReqs = 4429
+)
+
+// ErrSkipped is returned by a case that cannot run with the given Config.
+var ErrSkipped = errors.New("skipped")
+
+// Config describes the server under test.
+type Config struct {
+	// URL is the ws:// or wss:// endpoint of the server.
+	URL string
+
+	// Header is sent with every WebSocket handshake, for example to
+	// authenticate.
+	Header http.Header
+
+	// InitPayload is sent as the connection_init payload.
+	InitPayload map[string]any
+
+	// Subscription is a document that starts a long-running operation. Cases
+	// that need an active operation are skipped when it is empty. The server
+	// may emit next messages for it; they are ignored.
+	Subscription string
+
+	// Variables are sent along with Subscription.
+	Variables map[string]any
+
+	// InitTimeout is the server's connection initialisation timeout. The
+	// init timeout case waits up to twice this long for the server to close
+	// the socket. The default is 3 seconds. A negative value skips the case.
+	InitTimeout time.Duration
+
+	// Timeout bounds how long each expectation waits for the server. The
+	// default is 2 seconds.
+	Timeout time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.InitTimeout == 0 {
+		c.InitTimeout = 3 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 2 * time.Second
+	}
+	return c
+}
+
+// Case is a single conformance check.
+type Case struct {
+	Name string
+	Run  func(ctx context.Context, cfg Config) error
+}
+
+// Result is the outcome of a Case.
+type Result struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Passed reports whether the case ran and succeeded.
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+// Skipped reports whether the case did not run.
+func (r Result) Skipped() bool {
+	return errors.Is(r.Err, ErrSkipped)
+}
+
+// Report holds the results of a suite run.
+type Report struct {
+	Results []Result
+}
+
+// Passed reports whether no case failed. Skipped cases do not fail a run.
+func (r Report) Passed() bool {
+	for _, res := range r.Results {
+		if !res.Passed() && !res.Skipped() {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo writes a human-readable pass/fail report to w.
+func (r Report) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	var passed, failed, skipped int
+
+	for _, res := range r.Results {
+		switch {
+		case res.Passed():
+			passed++
+			fmt.Fprintf(&b, "PASS  %s (%s)\n", res.Name, res.Duration.Round(time.Millisecond))
+		case res.Skipped():
+			skipped++
+			fmt.Fprintf(&b, "SKIP  %s: %v\n", res.Name, res.Err)
+		default:
+			failed++
+			fmt.Fprintf(&b, "FAIL  %s: %v\n", res.Name, res.Err)
+		}
+	}
+	fmt.Fprintf(&b, "\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Run executes all cases against the server described by cfg.
+func Run(ctx context.Context, cfg Config) Report {
+	cfg = cfg.withDefaults()
+
+	var report Report
+	for _, c := range Cases() {
+		start := time.Now()
+		err := c.Run(ctx, cfg)
+		report.Results = append(report.Results, Result{Name: c.Name, Err: err, Duration: time.Since(start)})
+	}
+
+	return report
+}
+
+// RunTests executes all cases as subtests of t.
+func RunTests(t *testing.T, cfg Config) {
+	t.Helper()
+
+	cfg = cfg.withDefaults()
+	for _, c := range Cases() {
+		t.Run(c.Name, func(t *testing.T) {
+			err := c.Run(t.Context(), cfg)
+			switch {
+			case errors.Is(err, ErrSkipped):
+				t.Skip(err)
+			case err != nil:
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Cases returns the checks performed by Run.
+func Cases() []Case {
+	return []Case{
+		{Name: "connection_init timeout closes with 4408", Run: initTimeout},
+		{Name: "duplicate connection_init closes with 4429", Run: duplicateInit},
+		{Name: "subscribe before connection_ack closes with 4401", Run: subscribeBeforeAck},
+		{Name: "duplicate operation ID closes with 4409", Run: duplicateID},
+		{Name: "ping is answered with pong echoing the payload", Run: pingPong},
+		{Name: "pong is not answered", Run: unsolicitedPong},
+		{Name: "complete stops the operation", Run: completeStopsOperation},
+		{Name: "complete for an unknown ID is ignored", Run: completeUnknownID},
+		{Name: "operation ID can be reused after complete", Run: reuseIDAfterComplete},
+		{Name: "unknown message type closes with 4400", Run: unknownMessageType},
+		{Name: "invalid JSON closes with 4400", Run: invalidJSON},
+	}
+}
+
+func initTimeout(ctx context.Context, cfg Config) error {
+	if cfg.InitTimeout < 0 {
+		return fmt.Errorf("%w: InitTimeout is negative", ErrSkipped)
+	}
+
+	c, err := dial(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	c.timeout = 2 * cfg.InitTimeout
+	return c.expectClose(CloseConnectionInitTimeout)
+}
+
+func duplicateInit(ctx context.Context, cfg Config) error {
+	c, err := initialised(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.send(message{Type: "connection_init"}); err != nil {
+		return err
+	}
+	return c.expectClose(CloseTooManyInitialisationReqs)
+}
+
+func subscribeBeforeAck(ctx context.Context, cfg Config) error {
+	c, err := dial(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.subscribe("1", "{ __typename }", nil); err != nil {
+		return err
+	}
+	return c.expectClose(CloseUnauthorized)
+}
+
+func duplicateID(ctx context.Context, cfg Config) error {
+	if cfg.Subscription == "" {
+		return fmt.Errorf("%w: no Subscription configured", ErrSkipped)
+	}
+
+	c, err := initialised(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	for i := 0; i < 2; i++ {
+		if err := c.subscribe("1", cfg.Subscription, cfg.Variables); err != nil {
+			return err
+		}
+	}
+
+	return c.expectClose(CloseSubscriberAlreadyExists)
+}
+
+func pingPong(ctx context.Context, cfg Config) error {
+	c, err := initialised(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	return c.roundTrip("conformance-ping")
+}
+
+func unsolicitedPong(ctx context.Context, cfg Config) error {
+	c, err := initialised(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.send(message{Type: "pong", Payload: []byte(`{"unsolicited":true}`)}); err != nil {
+		return err
+	}
+
+	// The first message after the pong must be the reply to this ping.
+	return c.roundTrip("after-pong")
+}
+
+func completeStopsOperation(ctx context.Context, cfg Config) error {
+	if cfg.Subscription == "" {
+		return fmt.Errorf("%w: no Subscription configured", ErrSkipped)
+	}
+
+	c, err := initialised(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.subscribe("1", cfg.Subscription, cfg.Variables); err != nil {
+		return err
+	}
+	if err := c.send(message{ID: "1", Type: "complete"}); err != nil {
+		return err
+	}
+
+	// Messages for the operation may still be in flight until the server
+	// has processed complete; the pong marks that point.
+	if err := c.ping("after-complete"); err != nil {
+		return err
+	}
+	if _, err := c.readUntil(func(m message) bool { return m.Type == "pong" }); err != nil {
+		return err
+	}
+
+	msg, err := c.readQuiet(cfg.Timeout / 4)
+	if err != nil {
+		return err
+	}
+	if msg != nil {
+		return fmt.Errorf("received %s message for id %q after complete", msg.Type, msg.ID)
+	}
+
+	return nil
+}
+
+func completeUnknownID(ctx context.Context, cfg Config) error {
+	c, err := initialised(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.send(message{ID: "unknown", Type: "complete"}); err != nil {
+		return err
+	}
+	return c.roundTrip("after-unknown-complete")
+}
+
+func reuseIDAfterComplete(ctx context.Context, cfg Config) error {
+	if cfg.Subscription == "" {
+		return fmt.Errorf("%w: no Subscription configured", ErrSkipped)
+	}
+
+	c, err := initialised(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.subscribe("1", cfg.Subscription, cfg.Variables); err != nil {
+		return err
+	}
+	if err := c.send(message{ID: "1", Type: "complete"}); err != nil {
+		return err
+	}
+	if err := c.ping("after-complete"); err != nil {
+		return err
+	}
+	if _, err := c.readUntil(func(m message) bool { return m.Type == "pong" }); err != nil {
+		return err
+	}
+
+	if err := c.subscribe("1", cfg.Subscription, cfg.Variables); err != nil {
+		return err
+	}
+	if err := c.ping("after-resubscribe"); err != nil {
+		return err
+	}
+	_, err = c.readUntil(func(m message) bool { return m.Type == "pong" })
+	return err
+}
+
+func unknownMessageType(ctx context.Context, cfg Config) error {
+	c, err := initialised(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.send(message{Type: "conformance-unknown"}); err != nil {
+		return err
+	}
+	return c.expectClose(CloseBadRequest)
+}
+
+func invalidJSON(ctx context.Context, cfg Config) error {
+	c, err := initialised(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.sendRaw([]byte(`{"type":`)); err != nil {
+		return err
+	}
+	return c.expectClose(CloseBadRequest)
+}
@@ -0,0 +1,84 @@
+package conformance_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	graphqlws "github.com/graph-gophers/graphql-transport-ws"
+	"github.com/graph-gophers/graphql-transport-ws/conformance"
+)
+
+// idleSubscriber starts operations that never produce a value.
+type idleSubscriber struct{}
+
+func (idleSubscriber) Subscribe(ctx context.Context, document string, operation string, variables map[string]any) (<-chan any, error) {
+	return make(chan any), nil
+}
+
+func newServer(t *testing.T) conformance.Config {
+	t.Helper()
+
+	srv := httptest.NewServer(graphqlws.NewHandlerFunc(idleSubscriber{}, nil, graphqlws.WithWriteTimeout(100*time.Millisecond)))
+	t.Cleanup(srv.Close)
+
+	return conformance.Config{
+		URL:          "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Subscription: "subscription { ticks }",
+		InitTimeout:  100 * time.Millisecond,
+		Timeout:      time.Second,
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	conformance.RunTests(t, newServer(t))
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	cfg := newServer(t)
+	cfg.Subscription = ""
+
+	report := conformance.Run(context.Background(), cfg)
+	if !report.Passed() {
+		var b strings.Builder
+		_, _ = report.WriteTo(&b)
+		t.Fatalf("expected conformance run to pass:\n%s", b.String())
+	}
+
+	var skipped int
+	for _, res := range report.Results {
+		if res.Skipped() {
+			skipped++
+		}
+	}
+	if skipped != 3 {
+		t.Fatalf("expected 3 skipped cases without a subscription, got %d", skipped)
+	}
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	t.Parallel()
+
+	cfg := newServer(t)
+	// The server closes after 100ms, so expecting a 10ms timeout to be
+	// enforced within 20ms fails.
+	cfg.InitTimeout = 10 * time.Millisecond
+
+	report := conformance.Run(context.Background(), cfg)
+	if report.Passed() {
+		t.Fatal("expected conformance run to fail")
+	}
+	if report.Results[0].Passed() {
+		t.Fatalf("expected init timeout case to fail, got %+v", report.Results[0])
+	}
+
+	var b strings.Builder
+	_, _ = report.WriteTo(&b)
+	if !strings.Contains(b.String(), "FAIL  connection_init timeout") {
+		t.Fatalf("expected report to mention the failed case:\n%s", b.String())
+	}
+}
@@ -0,0 +1,261 @@
+package graphqlws
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MessageType is the type of a WebSocket data message.
+type MessageType int
+
+// The data message types defined in RFC 6455. The values match those used by
+// gorilla/websocket.
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// Conn is an upgraded WebSocket connection that speaks the
+// graphql-transport-ws subprotocol.
+//
+// The transport calls ReadMessage and SetReadDeadline from one goroutine,
+// WriteMessage and SetWriteDeadline from another, and Close once when the
+// connection is done. Implementations must allow reading and writing to run
+// concurrently, and must allow SetReadDeadline to be called while a
+// ReadMessage call is blocked.
+type Conn interface {
+	// ReadMessage returns the next data message. It returns a *CloseError
+	// when the peer sent a close frame and an error whose Timeout method
+	// reports true when the read deadline passed.
+	ReadMessage() (MessageType, []byte, error)
+
+	// WriteMessage writes a single data message.
+	WriteMessage(typ MessageType, data []byte) error
+
+	// SetReadDeadline sets the deadline for ReadMessage. A zero value means
+	// reads do not time out.
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline sets the deadline for WriteMessage and Close.
+	SetWriteDeadline(t time.Time) error
+
+	// SetReadLimit sets the maximum size in bytes of a message read from the
+	// peer.
+	SetReadLimit(limit int64)
+
+	// Close sends a close frame with code and reason and closes the
+	// underlying connection. A code of 0 closes the connection without a
+	// close frame.
+	Close(code int, reason string) error
+}
+
+// CloseError is returned by Conn.ReadMessage when the peer closed the
+// connection with a close frame.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Reason)
+}
+
+// isCloseError reports whether err is a *CloseError with one of codes.
+func isCloseError(err error, codes ...int) bool {
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		return false
+	}
+
+	for _, code := range codes {
+		if closeErr.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ServeConn runs the graphql-transport-ws protocol over ws, which must
+// already have been upgraded with the ProtocolGraphQLTransportWS subprotocol.
+// It blocks until the connection is closed, either by the peer, by a protocol
+// error or by cancelling ctx. ctx is the connection context that is passed on
+// to the Subscriber.
+//
+// Options that only apply to the HTTP upgrade are ignored: the caller is
+// responsible for them. These are WithContextGenerator, WithCheckOrigin,
+// WithSubprotocol, WithCompression and MetricsHooks.OnCompression. ServeConn
+// enforces no connection limits either: WithMaxConnections,
+// WithMaxConnectionsPerKey and WithConnectionKey are ignored and
+// MetricsHooks.OnConnectionRejected is never called. WithWriteBatching does
+// not coalesce network writes, which takes the connection created by the
+// handler.
+func ServeConn(ctx context.Context, ws Conn, sub Subscriber, options ...Option) {
+	o := applyOptions(options...)
+	connectTransport(ctx, ws, sub, o.transportOptions(sub)...)
+}
+
+// NewGorillaConn adapts a gorilla/websocket connection to Conn.
+func NewGorillaConn(ws *websocket.Conn) Conn {
+	return &gorillaConn{ws: ws}
+}
+
+type gorillaConn struct {
+	ws *websocket.Conn
+
+	// writeDeadline is also used for the close frame, which gorilla writes
+	// with an explicit deadline.
+	writeDeadline time.Time
+
+	// compressMinSize is the size from which messages are compressed, if
+	// compression was negotiated. wire and onCompression are set when
+	// compression is reported to the metrics hooks.
+	compress        bool
+	compressMinSize int
+	wire            *countingConn
+	onCompression   func(uncompressed, compressed int)
+
+	// batch buffers the frames of a batch, if write batching is enabled.
+	batch *batchConn
+}
+
+func (c *gorillaConn) ReadMessage() (MessageType, []byte, error) {
+	typ, data, err := c.ws.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return 0, nil, &CloseError{Code: closeErr.Code, Reason: closeErr.Text}
+	}
+
+	return MessageType(typ), data, err
+}
+
+func (c *gorillaConn) WriteMessage(typ MessageType, data []byte) error {
+	return c.write(len(data), func() error {
+		return c.ws.WriteMessage(int(typ), data)
+	})
+}
+
+// writeFrame writes a shared frame as a prepared message, so that it is
+// framed and compressed once for all connections.
+func (c *gorillaConn) writeFrame(f *sharedFrame) error {
+	pm, err := f.preparedMessage()
+	if err != nil {
+		return c.WriteMessage(f.typ, f.data)
+	}
+
+	return c.write(len(f.data), func() error {
+		return c.ws.WritePreparedMessage(pm)
+	})
+}
+
+// write runs write, which writes a message of size bytes, with compression
+// enabled as configured and reported to the metrics hooks. The message is
+// buffered if a batch is open.
+func (c *gorillaConn) write(size int, write func() error) error {
+	if c.batch != nil {
+		return c.batch.buffer(func() error {
+			return c.compressed(size, write)
+		})
+	}
+
+	return c.compressed(size, write)
+}
+
+// compressed runs write with compression enabled as configured.
+func (c *gorillaConn) compressed(size int, write func() error) error {
+	if !c.compress {
+		return write()
+	}
+
+	compress := size >= c.compressMinSize
+	c.ws.EnableWriteCompression(compress)
+	if !compress || c.onCompression == nil {
+		return write()
+	}
+
+	before := c.wire.written.Load()
+	if err := write(); err != nil {
+		return err
+	}
+	c.onCompression(size, int(c.wire.written.Load()-before))
+
+	return nil
+}
+
+func (c *gorillaConn) startBatch() {
+	if c.batch != nil {
+		c.batch.start()
+	}
+}
+
+func (c *gorillaConn) flushBatch(deadline time.Time) error {
+	if c.batch == nil {
+		return nil
+	}
+
+	return c.batch.flush(deadline)
+}
+
+func (c *gorillaConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *gorillaConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline = t
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *gorillaConn) SetReadLimit(limit int64) {
+	c.ws.SetReadLimit(limit)
+}
+
+// RemoteAddr returns the address of the client, for audit records.
+func (c *gorillaConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+// Subprotocol returns the negotiated subprotocol, for the DebugRegistry.
+func (c *gorillaConn) Subprotocol() string {
+	return c.ws.Subprotocol()
+}
+
+func (c *gorillaConn) Close(code int, reason string) error {
+	if code != 0 {
+		_ = c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), c.writeDeadline)
+	}
+
+	return c.ws.Close()
+}
+
+// hijackResponseWriter wraps the network connection with wrap when the
+// upgrader hijacks it.
+type hijackResponseWriter struct {
+	http.ResponseWriter
+	wrap func(net.Conn) net.Conn
+}
+
+func (w *hijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return w.wrap(conn), rw, nil
+}
+
+func (w *hijackResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,364 @@
+package graphqlws
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errCanceledByOperator = errors.New("operation canceled by the server")
+
+// DebugRegistry tracks the live connections of the handlers it is passed to
+// with WithDebugRegistry, and serves them for inspection with Handler.
+type DebugRegistry struct {
+	mu    sync.RWMutex
+	conns map[string]*connection
+}
+
+// NewDebugRegistry returns an empty DebugRegistry.
+func NewDebugRegistry() *DebugRegistry {
+	return &DebugRegistry{conns: make(map[string]*connection)}
+}
+
+// WithDebugRegistry registers every connection with r for as long as it is
+// open. A registry may be shared by several handlers.
+func WithDebugRegistry(r *DebugRegistry) Option {
+	return optionFunc(func(o *options) {
+		o.debug = r
+	})
+}
+
+func transportDebug(r *DebugRegistry) transportOption {
+	return func(conn *connection) {
+		conn.identify()
+		conn.debug = &debugConnection{
+			registry: r,
+			start:    time.Now(),
+			ops:      make(map[string]*debugOperation),
+		}
+		if sp, ok := conn.ws.(interface{ Subprotocol() string }); ok {
+			conn.debug.subprotocol = sp.Subprotocol()
+		}
+		conn.debug.touch()
+	}
+}
+
+// debugConnection is the state of a connection kept for the DebugRegistry.
+type debugConnection struct {
+	registry     *DebugRegistry
+	start        time.Time
+	subprotocol  string
+	lastActivity atomic.Int64 // unix nanoseconds
+
+	mu  sync.Mutex
+	ops map[string]*debugOperation
+}
+
+type debugOperation struct {
+	name     string
+	start    time.Time
+	messages atomic.Int64
+	stop     chan struct{} // closed to cancel the operation
+	stopOnce sync.Once
+}
+
+// register adds conn to the registry and returns the function removing it.
+func (r *DebugRegistry) register(conn *connection) func() {
+	r.mu.Lock()
+	r.conns[conn.id] = conn
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		delete(r.conns, conn.id)
+		r.mu.Unlock()
+	}
+}
+
+func (r *DebugRegistry) connection(id string) *connection {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.conns[id]
+}
+
+// touch records activity on the connection. It does nothing without a
+// DebugRegistry.
+func (d *debugConnection) touch() {
+	if d != nil {
+		d.lastActivity.Store(time.Now().UnixNano())
+	}
+}
+
+// startOperation tracks the operation id until the returned function is
+// called. It returns nil without a DebugRegistry.
+func (d *debugConnection) startOperation(id, name string) (*debugOperation, func()) {
+	if d == nil {
+		return nil, func() {}
+	}
+
+	op := &debugOperation{name: name, start: time.Now(), stop: make(chan struct{})}
+	d.mu.Lock()
+	d.ops[id] = op
+	d.mu.Unlock()
+
+	return op, func() {
+		d.mu.Lock()
+		if d.ops[id] == op {
+			delete(d.ops, id)
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *debugConnection) operation(id string) *debugOperation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.ops[id]
+}
+
+// wrap returns a sendFunc that passes messages to send and counts the next
+// messages that were queued.
+func (op *debugOperation) wrap(send sendFunc) sendFunc {
+	return func(msg *operationMessage) bool {
+		if !send(msg) {
+			return false
+		}
+		if msg.Type == typeNext {
+			op.messages.Add(1)
+		}
+		return true
+	}
+}
+
+// stopped returns a channel closed when an operator cancels the operation.
+// It returns nil without a DebugRegistry.
+func (op *debugOperation) stopped() <-chan struct{} {
+	if op == nil {
+		return nil
+	}
+	return op.stop
+}
+
+func (op *debugOperation) cancel() {
+	op.stopOnce.Do(func() { close(op.stop) })
+}
+
+// debugConnectionInfo describes a connection served by the debug handler.
+type debugConnectionInfo struct {
+	ID           string               `json:"id"`
+	RemoteAddr   string               `json:"remoteAddr,omitempty"`
+	Subprotocol  string               `json:"subprotocol,omitempty"`
+	Start        time.Time            `json:"start"`
+	Age          string               `json:"age"`
+	QueueDepth   int                  `json:"queueDepth"` // messages waiting to be written
+	LastActivity time.Time            `json:"lastActivity"`
+	Operations   []debugOperationInfo `json:"operations"`
+}
+
+type debugOperationInfo struct {
+	ID            string    `json:"id"`
+	OperationName string    `json:"operationName,omitempty"`
+	Start         time.Time `json:"start"`
+	Messages      int64     `json:"messages"`
+}
+
+// snapshot describes the live connections, oldest first.
+func (r *DebugRegistry) snapshot() []debugConnectionInfo {
+	r.mu.RLock()
+	conns := make([]*connection, 0, len(r.conns))
+	for _, conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	r.mu.RUnlock()
+
+	now := time.Now()
+	infos := make([]debugConnectionInfo, 0, len(conns))
+	for _, conn := range conns {
+		d := conn.debug
+		info := debugConnectionInfo{
+			ID:           conn.id,
+			RemoteAddr:   conn.remoteAddr,
+			Subprotocol:  d.subprotocol,
+			Start:        d.start,
+			Age:          now.Sub(d.start).Truncate(time.Second).String(),
+			QueueDepth:   int(conn.queued.Load()),
+			LastActivity: time.Unix(0, d.lastActivity.Load()),
+			Operations:   []debugOperationInfo{},
+		}
+
+		d.mu.Lock()
+		for id, op := range d.ops {
+			info.Operations = append(info.Operations, debugOperationInfo{
+				ID:            id,
+				OperationName: op.name,
+				Start:         op.start,
+				Messages:      op.messages.Load(),
+			})
+		}
+		d.mu.Unlock()
+
+		slices.SortFunc(info.Operations, func(a, b debugOperationInfo) int {
+			return a.Start.Compare(b.Start)
+		})
+		infos = append(infos, info)
+	}
+
+	slices.SortFunc(infos, func(a, b debugConnectionInfo) int {
+		return a.Start.Compare(b.Start)
+	})
+	return infos
+}
+
+// Handler returns an http.Handler listing the live connections, as HTML or,
+// with the query parameter format=json or an Accept header of
+// application/json, as JSON.
+//
+// POST requests with the form values action=close and connection=ID close
+// a connection, and action=cancel, connection=ID and operation=ID cancel an
+// operation, which receives an error message. Actions are only allowed when
+// allowActions reports true for the request; a nil allowActions makes the
+// handler read-only. Cross-origin POST requests are always rejected.
+//
+// Like net/http/pprof, the handler exposes details of every client and must
+// not be reachable from untrusted networks.
+func (r *DebugRegistry) Handler(allowActions func(*http.Request) bool) http.Handler {
+	return &debugHandler{registry: r, allowActions: allowActions}
+}
+
+type debugHandler struct {
+	registry     *DebugRegistry
+	allowActions func(*http.Request) bool
+}
+
+func (h *debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.list(w, r)
+	case http.MethodPost:
+		h.action(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *debugHandler) list(w http.ResponseWriter, r *http.Request) {
+	conns := h.registry.snapshot()
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(conns)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = debugTemplate.Execute(w, struct {
+		Connections []debugConnectionInfo
+		Actions     bool
+	}{conns, h.allowActions != nil && h.allowActions(r)})
+}
+
+func (h *debugHandler) action(w http.ResponseWriter, r *http.Request) {
+	if h.allowActions == nil || !sameOrigin(r) || !h.allowActions(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	conn := h.registry.connection(r.FormValue("connection"))
+	if conn == nil {
+		http.Error(w, "unknown connection", http.StatusNotFound)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "close":
+		conn.closeWithCode(closeCodeGoingAway, "Closed by the server")
+	case "cancel":
+		op := conn.debug.operation(r.FormValue("operation"))
+		if op == nil {
+			http.Error(w, "unknown operation", http.StatusNotFound)
+			return
+		}
+		op.cancel()
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+
+	if wantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// sameOrigin reports whether r was not sent by a page of another origin, so
+// that other sites cannot submit actions with the credentials of an operator.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>graphql-transport-ws connections</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+form { display: inline; }
+</style>
+</head>
+<body>
+<h1>{{len .Connections}} live connections</h1>
+<table>
+<tr><th>ID</th><th>Remote address</th><th>Subprotocol</th><th>Age</th><th>Queue</th><th>Last activity</th><th>Operations</th>{{if .Actions}}<th></th>{{end}}</tr>
+{{- $actions := .Actions}}
+{{- range .Connections}}
+{{- $conn := .ID}}
+<tr>
+<td>{{.ID}}</td>
+<td>{{.RemoteAddr}}</td>
+<td>{{.Subprotocol}}</td>
+<td>{{.Age}}</td>
+<td>{{.QueueDepth}}</td>
+<td>{{.LastActivity.Format "2006-01-02T15:04:05.000Z07:00"}}</td>
+<td>
+{{- range .Operations}}
+<div>{{.ID}} {{.OperationName}}: {{.Messages}} messages
+{{- if $actions}}
+<form method="post"><input type="hidden" name="action" value="cancel"><input type="hidden" name="connection" value="{{$conn}}"><input type="hidden" name="operation" value="{{.ID}}"><button>Cancel</button></form>
+{{- end}}</div>
+{{- end}}
+</td>
+{{- if $actions}}
+<td><form method="post"><input type="hidden" name="action" value="close"><input type="hidden" name="connection" value="{{.ID}}"><button>Close</button></form></td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
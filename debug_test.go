@@ -0,0 +1,174 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// debugList fetches the connections listed by h.
+func debugList(t *testing.T, h http.Handler) []debugConnectionInfo {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug?format=json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d", rec.Code)
+	}
+
+	var conns []debugConnectionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &conns); err != nil {
+		t.Fatal(err)
+	}
+	return conns
+}
+
+func debugPost(h http.Handler, form url.Values, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/debug", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDebugQueueDepth(t *testing.T) {
+	t.Parallel()
+
+	const events = 20
+
+	h := setupTest(t)
+	h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+		c := make(chan any, events)
+		for i := range events {
+			c <- i
+		}
+		close(c)
+		return c, nil
+	}
+
+	reg := NewDebugRegistry()
+	go connectTransport(context.Background(), h.conn, h.mockSvc, transportDebug(reg))
+	defer close(h.conn.in)
+
+	h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+	h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`)
+
+	// The client reads nothing, so once the connection's buffer is full one
+	// message is being written, one is queued and the operation waits to
+	// queue the next.
+	const want = 3
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		conns := debugList(t, reg.Handler(nil))
+		if len(conns) == 1 && conns[0].QueueDepth == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want queue depth %d, got %+v", want, conns)
+		}
+	}
+
+	requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+	for range events {
+		requireMessageType(t, requireMessage(t, h.conn), "next")
+	}
+	requireMessageType(t, requireMessage(t, h.conn), "complete")
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		conns := debugList(t, reg.Handler(nil))
+		if len(conns) == 1 && conns[0].QueueDepth == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want an empty queue, got %+v", conns)
+		}
+	}
+}
+
+func TestDebugRegistry(t *testing.T) {
+	t.Parallel()
+
+	h := setupTest(t)
+	h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+		c := make(chan any, 1)
+		c <- "a"
+		return c, nil
+	}
+
+	reg := NewDebugRegistry()
+	readOnly := reg.Handler(nil)
+	admin := reg.Handler(func(r *http.Request) bool { return true })
+
+	go connectTransport(context.Background(), h.conn, h.mockSvc, transportDebug(reg))
+	defer close(h.conn.in)
+
+	h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+	requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+	h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription Count { count }","operationName":"Count"}}`)
+	requireMessageType(t, requireMessage(t, h.conn), "next")
+
+	// The message is counted once it is queued, which may be after it was
+	// written.
+	var conns []debugConnectionInfo
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		conns = debugList(t, readOnly)
+		if len(conns) == 1 && len(conns[0].Operations) == 1 && conns[0].Operations[0].Messages == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected connections %+v", conns)
+		}
+	}
+	conn := conns[0]
+	if conn.ID == "" || conn.Operations[0].ID != "1" || conn.Operations[0].OperationName != "Count" || conn.LastActivity.Before(conn.Start) {
+		t.Fatalf("unexpected connection %+v", conn)
+	}
+
+	rec := httptest.NewRecorder()
+	readOnly.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug", nil))
+	if body := rec.Body.String(); !strings.Contains(body, conn.ID) || !strings.Contains(body, "Count") || strings.Contains(body, "<form") {
+		t.Fatalf("unexpected page %s", body)
+	}
+
+	cancel := url.Values{"action": {"cancel"}, "connection": {conn.ID}, "operation": {"1"}}
+	if rec := debugPost(readOnly, cancel, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("want a read-only handler to forbid actions, got %d", rec.Code)
+	}
+	if rec := debugPost(admin, cancel, "https://evil.example"); rec.Code != http.StatusForbidden {
+		t.Fatalf("want cross-origin actions to be forbidden, got %d", rec.Code)
+	}
+	if rec := debugPost(admin, url.Values{"action": {"cancel"}, "connection": {conn.ID}, "operation": {"2"}}, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("want an unknown operation to be not found, got %d", rec.Code)
+	}
+
+	if rec := debugPost(admin, cancel, ""); rec.Code != http.StatusSeeOther {
+		t.Fatalf("want status 303, got %d", rec.Code)
+	}
+	requireEqualJSON(t, `{"id":"1","type":"error","payload":[{"message":"operation canceled by the server"}]}`, requireMessage(t, h.conn), "")
+
+	if rec := debugPost(admin, url.Values{"action": {"close"}, "connection": {conn.ID}}, ""); rec.Code != http.StatusSeeOther {
+		t.Fatalf("want status 303, got %d", rec.Code)
+	}
+	requireClosed(t, h.conn)
+
+	h.conn.mtx.Lock()
+	code := h.conn.closeCode
+	h.conn.mtx.Unlock()
+	if code != closeCodeGoingAway {
+		t.Fatalf("want close code %d, got %d", closeCodeGoingAway, code)
+	}
+
+	for deadline := time.Now().Add(time.Second); len(debugList(t, readOnly)) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("closed connection still listed")
+		}
+	}
+}
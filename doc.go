@@ -10,6 +10,9 @@
 // connection initialisation, ping/pong, subscribe/next/error/complete flow,
 // and protocol close codes for invalid client behavior.
 //
+// Connections upgraded by other code, or by other WebSocket libraries, can be
+// served with ServeConn through the Conn interface.
+//
 // A Subscriber implementation is required to execute operations:
 //
 //	type Subscriber interface {
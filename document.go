@@ -0,0 +1,494 @@
+package graphqlws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// tokenKind is the kind of a lexical token of a GraphQL document.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenNumber
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	loc   gqlerrors.Location
+}
+
+// lexer splits a GraphQL document into tokens, skipping comments and
+// insignificant characters. It expects a syntactically valid document.
+type lexer struct {
+	src       string
+	pos       int
+	line      int
+	lineStart int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, loc: l.loc()}, nil
+	}
+
+	start, loc := l.pos, l.loc()
+	c := l.src[l.pos]
+
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokenPunctuator, value: "...", loc: loc}, nil
+
+	case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunctuator, value: string(c), loc: loc}, nil
+
+	case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		for l.pos < len(l.src) && isNameByte(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], loc: loc}, nil
+
+	case c == '-' || '0' <= c && c <= '9':
+		l.pos++
+		for l.pos < len(l.src) {
+			c := l.src[l.pos]
+			if '0' <= c && c <= '9' || c == '.' || c == 'e' || c == 'E' ||
+				(c == '+' || c == '-') && (l.src[l.pos-1] == 'e' || l.src[l.pos-1] == 'E') {
+				l.pos++
+				continue
+			}
+			break
+		}
+		return token{kind: tokenNumber, value: l.src[start:l.pos], loc: loc}, nil
+
+	case c == '"':
+		n := stringLen(l.src[l.pos:])
+		if lit := l.src[l.pos : l.pos+n]; strings.HasPrefix(lit, `"""`) && (n < 6 || !strings.HasSuffix(lit, `"""`)) || n < 2 || lit[n-1] != '"' {
+			return token{}, syntaxError(loc, "unterminated string")
+		}
+		l.advance(n)
+		return token{kind: tokenString, value: l.src[start:l.pos], loc: loc}, nil
+	}
+
+	return token{}, syntaxError(loc, "unexpected character %q", c)
+}
+
+// skipIgnored skips whitespace, line terminators, commas, comments and
+// byte order marks.
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.pos++
+			l.line++
+			l.lineStart = l.pos
+		case c == '\r':
+			l.pos++
+			if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+				l.pos++
+			}
+			l.line++
+			l.lineStart = l.pos
+		case c == ' ' || c == '\t' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "\ufeff"):
+			l.pos += len("\ufeff")
+		default:
+			return
+		}
+	}
+}
+
+// advance moves past n bytes that may contain line terminators, as block
+// strings do.
+func (l *lexer) advance(n int) {
+	end := l.pos + n
+	for l.pos < end {
+		if l.src[l.pos] == '\n' || l.src[l.pos] == '\r' && (l.pos+1 >= end || l.src[l.pos+1] != '\n') {
+			l.line++
+			l.lineStart = l.pos + 1
+		}
+		l.pos++
+	}
+}
+
+func (l *lexer) loc() gqlerrors.Location {
+	return gqlerrors.Location{Line: l.line, Column: l.pos - l.lineStart + 1}
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func syntaxError(loc gqlerrors.Location, format string, args ...any) *gqlerrors.QueryError {
+	err := gqlerrors.Errorf("syntax error: "+format, args...)
+	err.Locations = []gqlerrors.Location{loc}
+	return err
+}
+
+// document is the part of an executable GraphQL document the transport
+// inspects.
+type document struct {
+	operations []*operationDefinition
+	fragments  map[string]*fragmentDefinition
+}
+
+type operationDefinition struct {
+	typ        string // query, mutation or subscription
+	name       string
+	loc        gqlerrors.Location
+	selections []*selection
+}
+
+type fragmentDefinition struct {
+	name          string
+	typeCondition string
+	selections    []*selection
+}
+
+// selection is a field, a fragment spread or an inline fragment.
+type selection struct {
+	field         string         // the name of a field
+	arguments     map[string]any // the arguments of a field
+	fragment      string         // the name of a spread fragment
+	typeCondition string         // the type condition of an inline fragment, if any
+	selections    []*selection
+	loc           gqlerrors.Location
+}
+
+// variable is a variable used as an argument value.
+type variable string
+
+// parseDocument parses an executable GraphQL document.
+func parseDocument(src string) (doc *document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			qErr, ok := r.(*gqlerrors.QueryError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, qErr
+		}
+	}()
+
+	p := &parser{lex: newLexer(src)}
+	p.advance()
+
+	doc = &document{fragments: make(map[string]*fragmentDefinition)}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			op := &operationDefinition{typ: "query", loc: p.tok.loc}
+			op.selections = p.parseSelectionSet()
+			doc.operations = append(doc.operations, op)
+
+		case p.tok.kind == tokenName && (p.tok.value == "query" || p.tok.value == "mutation" || p.tok.value == "subscription"):
+			op := &operationDefinition{typ: p.tok.value, loc: p.tok.loc}
+			p.advance()
+			if p.tok.kind == tokenName {
+				op.name = p.parseName()
+			}
+			if p.peek("(") {
+				p.skipBalanced()
+			}
+			p.skipDirectives()
+			op.selections = p.parseSelectionSet()
+			doc.operations = append(doc.operations, op)
+
+		case p.tok.kind == tokenName && p.tok.value == "fragment":
+			p.advance()
+			f := &fragmentDefinition{name: p.parseName()}
+			p.expect("on")
+			f.typeCondition = p.parseName()
+			p.skipDirectives()
+			f.selections = p.parseSelectionSet()
+			doc.fragments[f.name] = f
+
+		default:
+			p.unexpected("an operation or fragment")
+		}
+	}
+
+	return doc, nil
+}
+
+// operation returns the operation of the document that a request with
+// operationName executes.
+func (d *document) operation(operationName string) (*operationDefinition, error) {
+	if len(d.operations) == 0 {
+		return nil, fmt.Errorf("no operations in query document")
+	}
+
+	if operationName == "" {
+		if len(d.operations) > 1 {
+			return nil, fmt.Errorf("more than one operation in query document and no operation name given")
+		}
+		return d.operations[0], nil
+	}
+
+	for _, op := range d.operations {
+		if op.name == operationName {
+			return op, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no operation with name %q", operationName)
+}
+
+// parser is a recursive descent parser for executable documents. It panics
+// with a *gqlerrors.QueryError on syntax errors, which parseDocument
+// recovers.
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) advance() {
+	tok, err := p.lex.next()
+	if err != nil {
+		panic(err)
+	}
+	p.tok = tok
+}
+
+// peek reports whether the current token is the punctuator or name value.
+func (p *parser) peek(value string) bool {
+	return (p.tok.kind == tokenPunctuator || p.tok.kind == tokenName) && p.tok.value == value
+}
+
+func (p *parser) expect(value string) {
+	if !p.peek(value) {
+		p.unexpected(fmt.Sprintf("%q", value))
+	}
+	p.advance()
+}
+
+func (p *parser) unexpected(expected string) {
+	if p.tok.kind == tokenEOF {
+		panic(syntaxError(p.tok.loc, "unexpected end of document, expecting %s", expected))
+	}
+	panic(syntaxError(p.tok.loc, "unexpected %q, expecting %s", p.tok.value, expected))
+}
+
+func (p *parser) parseName() string {
+	if p.tok.kind != tokenName {
+		p.unexpected("a name")
+	}
+	name := p.tok.value
+	p.advance()
+	return name
+}
+
+// skipBalanced skips the tokens from an opening bracket up to and including
+// the matching closing bracket.
+func (p *parser) skipBalanced() {
+	depth := 0
+	for {
+		if p.tok.kind == tokenEOF {
+			p.unexpected("a closing bracket")
+		}
+
+		if p.tok.kind == tokenPunctuator {
+			switch p.tok.value {
+			case "{", "(", "[":
+				depth++
+			case "}", ")", "]":
+				depth--
+			}
+		}
+
+		p.advance()
+		if depth == 0 {
+			return
+		}
+	}
+}
+
+func (p *parser) skipDirectives() {
+	for p.peek("@") {
+		p.advance()
+		p.parseName()
+		if p.peek("(") {
+			p.skipBalanced()
+		}
+	}
+}
+
+func (p *parser) parseSelectionSet() []*selection {
+	p.expect("{")
+
+	var selections []*selection
+	for {
+		selections = append(selections, p.parseSelection())
+		if p.peek("}") {
+			p.advance()
+			return selections
+		}
+	}
+}
+
+func (p *parser) parseSelection() *selection {
+	s := &selection{loc: p.tok.loc}
+
+	if p.peek("...") {
+		p.advance()
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			s.fragment = p.parseName()
+			p.skipDirectives()
+			return s
+		}
+
+		if p.peek("on") {
+			p.advance()
+			s.typeCondition = p.parseName()
+		}
+		p.skipDirectives()
+		s.selections = p.parseSelectionSet()
+		return s
+	}
+
+	s.field = p.parseName()
+	if p.peek(":") {
+		p.advance()
+		s.field = p.parseName()
+	}
+	if p.peek("(") {
+		s.arguments = p.parseArguments()
+	}
+	p.skipDirectives()
+	if p.peek("{") {
+		s.selections = p.parseSelectionSet()
+	}
+
+	return s
+}
+
+func (p *parser) parseArguments() map[string]any {
+	p.expect("(")
+
+	args := make(map[string]any)
+	for !p.peek(")") {
+		name := p.parseName()
+		p.expect(":")
+		args[name] = p.parseValue()
+	}
+	p.advance()
+
+	return args
+}
+
+// parseValue parses a value literal into the types encoding/json decodes
+// JSON into. Variables are returned as a variable.
+func (p *parser) parseValue() any {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokenPunctuator:
+		switch tok.value {
+		case "$":
+			p.advance()
+			return variable(p.parseName())
+
+		case "[":
+			p.advance()
+			list := []any{}
+			for !p.peek("]") {
+				list = append(list, p.parseValue())
+			}
+			p.advance()
+			return list
+
+		case "{":
+			p.advance()
+			obj := make(map[string]any)
+			for !p.peek("}") {
+				name := p.parseName()
+				p.expect(":")
+				obj[name] = p.parseValue()
+			}
+			p.advance()
+			return obj
+		}
+
+	case tokenNumber:
+		p.advance()
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			panic(syntaxError(tok.loc, "invalid number %q", tok.value))
+		}
+		return f
+
+	case tokenString:
+		p.advance()
+		return stringValue(tok.value)
+
+	case tokenName:
+		p.advance()
+		switch tok.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return tok.value // enum value
+	}
+
+	p.unexpected("a value")
+	return nil
+}
+
+// stringValue returns the value of a string or block string literal.
+func stringValue(lit string) string {
+	if strings.HasPrefix(lit, `"""`) {
+		return strings.ReplaceAll(lit[3:len(lit)-3], `\"""`, `"""`)
+	}
+
+	var s string
+	if err := json.Unmarshal([]byte(lit), &s); err != nil {
+		return lit[1 : len(lit)-1]
+	}
+	return s
+}
+
+// resolveVariables returns v with variables replaced by their values.
+func resolveVariables(v any, vars map[string]any) any {
+	switch v := v.(type) {
+	case variable:
+		return vars[string(v)]
+	case []any:
+		list := make([]any, len(v))
+		for i, e := range v {
+			list[i] = resolveVariables(e, vars)
+		}
+		return list
+	case map[string]any:
+		obj := make(map[string]any, len(v))
+		for k, e := range v {
+			obj[k] = resolveVariables(e, vars)
+		}
+		return obj
+	}
+	return v
+}
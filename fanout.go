@@ -0,0 +1,161 @@
+package graphqlws
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// fanOutBuffer is the number of events buffered for each listener of a
+// fanOut.
+const fanOutBuffer = 16
+
+var (
+	errNilSubscription    = errors.New("subscriber returned nil channel")
+	errSubscriptionLagged = errors.New("subscription fell behind")
+)
+
+// eventError is handed to a listener instead of an event. It ends the
+// operation with err.
+type eventError struct {
+	err error
+}
+
+// fanOut runs a single upstream subscription and hands its events to any
+// number of listeners, the operations sharing it. A listener that falls
+// more than fanOutBuffer events behind is handed errSubscriptionLagged,
+// which ends its operation, and removed, so that it never delays the
+// others.
+//
+// The owner of a fanOut keeps track of when it runs. Where joining and
+// leaving must be atomic with the owner's state, the owner calls join,
+// leave, send and end with a lock of its own held.
+type fanOut struct {
+	cancel func()
+	ready  chan struct{}
+	err    error // set before ready is closed
+
+	mu        sync.Mutex
+	listeners map[chan any]struct{}
+	ended     bool
+}
+
+// newFanOut returns a fanOut and the context to start its upstream
+// subscription with: ctx without its cancellation, as the subscription
+// outlives the operation that starts it.
+func newFanOut(ctx context.Context) (*fanOut, context.Context) {
+	upstream, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f := &fanOut{
+		cancel:    cancel,
+		ready:     make(chan struct{}),
+		listeners: make(map[chan any]struct{}),
+	}
+
+	return f, upstream
+}
+
+// start starts the upstream subscription with sub and reports the outcome
+// to the listeners waiting in wait. If the subscription fails, forget is
+// called first, so that no operation joins the failed fanOut, and the
+// listeners are completed.
+func (f *fanOut) start(ctx context.Context, sub Subscriber, doc string, operation string, vars map[string]any, forget func()) (<-chan any, bool) {
+	c, err := sub.Subscribe(ctx, doc, operation, vars)
+	if err == nil && c == nil {
+		err = errNilSubscription
+	}
+	if err != nil {
+		forget()
+		f.err = err
+		f.end()
+		f.cancel()
+		close(f.ready)
+		return nil, false
+	}
+	close(f.ready)
+
+	return c, true
+}
+
+// wait waits until the upstream subscription started and returns the error
+// it failed with, or the error of ctx.
+func (f *fanOut) wait(ctx context.Context) error {
+	select {
+	case <-f.ready:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// join adds a listener and returns its channel.
+func (f *fanOut) join() chan any {
+	l := make(chan any, fanOutBuffer+1) // one more for errSubscriptionLagged
+
+	f.mu.Lock()
+	f.listeners[l] = struct{}{}
+	f.mu.Unlock()
+
+	return l
+}
+
+// leave removes the listener l and reports whether it was the last one.
+func (f *fanOut) leave(l chan any) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.listeners[l]; !ok || f.ended {
+		return false
+	}
+	delete(f.listeners, l)
+
+	return len(f.listeners) == 0
+}
+
+// send hands v to every listener without blocking. It reports whether it
+// removed the last listener because it fell behind.
+func (f *fanOut) send(v any) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var lagged bool
+	for l := range f.listeners {
+		if len(l) >= fanOutBuffer {
+			l <- eventError{errSubscriptionLagged}
+			close(l)
+			delete(f.listeners, l)
+			lagged = true
+			continue
+		}
+		l <- v
+	}
+
+	return lagged && len(f.listeners) == 0
+}
+
+// idle reports whether the fanOut has no listeners and has not ended.
+func (f *fanOut) idle() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.listeners) == 0 && !f.ended
+}
+
+// end completes the listeners once the upstream subscription ended.
+func (f *fanOut) end() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ended {
+		return
+	}
+	f.ended = true
+	for l := range f.listeners {
+		close(l)
+	}
+	f.listeners = nil
+}
+
+// stop cancels the upstream subscription.
+func (f *fanOut) stop() {
+	f.cancel()
+}
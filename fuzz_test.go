@@ -0,0 +1,230 @@
+package graphqlws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// documentedCloseCodes are the close codes the transport may use.
+var documentedCloseCodes = map[int]bool{
+	closeCodeNormalClosure:             true, // read idle timeout
+	closeCodeInternalServerError:       true,
+	closeCodeBadRequest:                true,
+	closeCodeUnauthorized:              true,
+	closeCodeConnectionInitTimeout:     true,
+	closeCodeSubscriberAlreadyExists:   true,
+	closeCodeTooManyInitialisationReqs: true,
+}
+
+// fuzzSubscriber derives its behavior from the document so that the fuzzer
+// can reach every outcome of runSubscription. All streams are finite.
+type fuzzSubscriber struct{}
+
+func (fuzzSubscriber) Subscribe(ctx context.Context, document string, operation string, variables map[string]any) (<-chan any, error) {
+	switch {
+	case strings.Contains(document, "error"):
+		return nil, errors.New("subscribe failed")
+	case strings.Contains(document, "nil"):
+		return nil, nil
+	}
+
+	n := len(document) % 4
+	c := make(chan any)
+	go func() {
+		defer close(c)
+		for i := 0; i < n; i++ {
+			var v any = map[string]any{"data": map[string]int{"n": i}}
+			if strings.Contains(document, "bad") {
+				v = func() {}
+			}
+
+			select {
+			case c <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// FuzzTransport feeds newline separated client frames through the transport
+// and checks protocol invariants on the server's replies.
+func FuzzTransport(f *testing.F) {
+	seeds := [][]string{
+		{`{"type":"connection_init"}`},
+		{`{"type":"connection_init"}`, `{"type":"connection_init"}`},
+		{`{"id":"1","type":"subscribe","payload":{}}`},
+		{`{"type":"connection_init","payload":{"token":"abc"}}`, `{"type":"ping","payload":{"a":1}}`, `{"type":"pong"}`},
+		{`{"type":"connection_init"}`, `{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`},
+		{`{"type":"connection_init"}`, `{"id":"1","type":"subscribe","payload":{"query":"sub"}}`, `{"id":"1","type":"subscribe","payload":{"query":"sub"}}`},
+		{`{"type":"connection_init"}`, `{"id":"1","type":"subscribe","payload":{"query":"abc"}}`, `{"id":"1","type":"complete"}`},
+		{`{"type":"connection_init"}`, `{"id":"e","type":"subscribe","payload":{"query":"error"}}`, `{"id":"n","type":"subscribe","payload":{"query":"nil"}}`},
+		{`{"type":"connection_init"}`, `{"id":"b","type":"subscribe","payload":{"query":"bad"}}`},
+		{`{"type":"connection_init"}`, `{"id":"1","type":"subscribe","payload":{"query":"a"}}`, `{"id":"2","type":"subscribe","payload":{"query":"ab"}}`, `{"id":"3","type":"subscribe","payload":{"query":"abc"}}`},
+		{`{"type":"connection_init"}`, `{"id":"1","type":"subscribe","payload":"bad"}`},
+		{`{"type":"connection_init"}`, `{"type":"complete"}`},
+		{`{"type":"connection_init"}`, `{"type":"banana"}`},
+		{`{"type":`},
+	}
+	for _, frames := range seeds {
+		f.Add([]byte(strings.Join(frames, "\n")))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frames := bytes.Split(data, []byte("\n"))
+		if len(frames) > 32 {
+			frames = frames[:32]
+		}
+
+		baseline := runtime.NumGoroutine()
+
+		conn := newMockConnection()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			connectTransport(context.Background(), conn, fuzzSubscriber{}, transportWriteTimeout(50*time.Millisecond), transportMaxOperations(2))
+		}()
+
+		received := make(chan operationMessage, 64)
+		go func() {
+			defer close(received)
+			for raw := range conn.out {
+				var msg operationMessage
+				if err := json.Unmarshal(raw, &msg); err != nil {
+					t.Errorf("server sent invalid JSON %s: %v", raw, err)
+				}
+				received <- msg
+			}
+		}()
+
+		subscribes := make(map[string]int)
+		completed := make(map[string]bool)
+
+	send:
+		for _, frame := range frames {
+			select {
+			case conn.in <- json.RawMessage(frame):
+			case <-conn.closeCalled:
+				break send
+			}
+
+			var msg operationMessage
+			if json.Unmarshal(frame, &msg) != nil {
+				continue
+			}
+			switch msg.Type {
+			case typeSubscribe:
+				subscribes[msg.ID]++
+			case typeComplete:
+				completed[msg.ID] = true
+			}
+		}
+
+		var msgs []operationMessage
+		terminals := make(map[string]int)
+		record := func(msg operationMessage) {
+			msgs = append(msgs, msg)
+			if msg.Type == typeError || msg.Type == typeComplete {
+				terminals[msg.ID]++
+			}
+		}
+		settled := func() bool {
+			for id, n := range subscribes {
+				if !completed[id] && terminals[id] < n {
+					return false
+				}
+			}
+			return len(msgs) > 0
+		}
+
+		// Wait for the operations the client did not complete to terminate
+		// on their own before hanging up.
+		timeout := time.After(2 * time.Second)
+	wait:
+		for !settled() {
+			select {
+			case msg, ok := <-received:
+				if !ok {
+					break wait
+				}
+				record(msg)
+			case <-conn.closeCalled:
+				break wait
+			case <-timeout:
+				break wait
+			}
+		}
+		close(conn.in)
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("transport did not return after the client hung up")
+		}
+		for msg := range received {
+			record(msg)
+		}
+
+		conn.mtx.Lock()
+		closeCode := conn.closeCode
+		conn.mtx.Unlock()
+
+		if closeCode != 0 && !documentedCloseCodes[closeCode] {
+			t.Fatalf("undocumented close code %d", closeCode)
+		}
+
+		seen := make(map[string]int)
+		for i, msg := range msgs {
+			switch msg.Type {
+			case typeConnectionAck:
+				if i != 0 {
+					t.Fatalf("connection_ack sent as message %d", i)
+				}
+			case typeNext:
+				if seen[msg.ID] >= subscribes[msg.ID] {
+					t.Fatalf("next for %q after its operation terminated", msg.ID)
+				}
+			case typeError, typeComplete:
+				seen[msg.ID]++
+				if seen[msg.ID] > subscribes[msg.ID] {
+					t.Fatalf("%d terminal messages for %q but only %d subscribes", seen[msg.ID], msg.ID, subscribes[msg.ID])
+				}
+			case typePong:
+			default:
+				t.Fatalf("server sent unexpected message type %q", msg.Type)
+			}
+		}
+
+		if closeCode == 0 {
+			for id, n := range subscribes {
+				if !completed[id] && terminals[id] != n {
+					t.Fatalf("operation %q: %d subscribes ended with %d terminal messages", id, n, terminals[id])
+				}
+			}
+		}
+
+		requireNoGoroutineLeak(t, baseline)
+	})
+}
+
+func requireNoGoroutineLeak(t *testing.T, baseline int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("goroutine leak: %d running, %d before\n%s", runtime.NumGoroutine(), baseline, buf)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
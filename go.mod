@@ -3,6 +3,10 @@ module github.com/graph-gophers/graphql-transport-ws
 go 1.25
 
 require (
+	github.com/coder/websocket v1.8.14
 	github.com/gorilla/websocket v1.5.3
 	github.com/graph-gophers/graphql-go v1.9.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 )
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
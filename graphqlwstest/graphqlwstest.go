@@ -0,0 +1,258 @@
+// Package graphqlwstest provides a test harness for GraphQL over WebSocket
+// servers built with graphqlws.
+//
+// A Server runs a handler on an in-memory listener, so tests exercise the
+// real graphql-transport-ws protocol implementation without opening network
+// sockets. Clients connected to it offer helpers that send protocol messages
+// and assert on the server's replies, reporting failures through testing.TB:
+//
+//	c := graphqlwstest.Connect(t, schema)
+//	c.Init(nil)
+//	c.Subscribe("1", "subscription { ticks { number } }", nil)
+//	next := c.ExpectNext("1")
+//	c.ExpectComplete("1")
+package graphqlwstest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	graphqlws "github.com/graph-gophers/graphql-transport-ws"
+)
+
+// DefaultTimeout is how long a Client waits for an expected server message.
+const DefaultTimeout = time.Second
+
+// Message is a graphql-transport-ws protocol message.
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Server serves an http.Handler over an in-memory listener.
+type Server struct {
+	ln *pipeListener
+}
+
+// NewServer starts serving h. The server is shut down when the test ends.
+func NewServer(tb testing.TB, h http.Handler) *Server {
+	tb.Helper()
+
+	ln := newPipeListener()
+	srv := &http.Server{Handler: h}
+	go func() { _ = srv.Serve(ln) }()
+
+	tb.Cleanup(func() { _ = srv.Close() })
+
+	return &Server{ln: ln}
+}
+
+// Dial opens a WebSocket connection with the graphql-transport-ws
+// subprotocol. The connection is closed when the test ends.
+func (s *Server) Dial(tb testing.TB, header http.Header) *Client {
+	tb.Helper()
+
+	dialer := websocket.Dialer{
+		NetDialContext:   s.ln.dial,
+		Subprotocols:     []string{graphqlws.ProtocolGraphQLTransportWS},
+		HandshakeTimeout: DefaultTimeout,
+	}
+
+	ws, _, err := dialer.Dial("ws://"+s.ln.Addr().String()+"/", header)
+	if err != nil {
+		tb.Fatalf("graphqlwstest: dial failed: %v", err)
+	}
+
+	tb.Cleanup(func() { _ = ws.Close() })
+
+	return &Client{Timeout: DefaultTimeout, tb: tb, ws: ws}
+}
+
+// Connect serves sub with graphqlws.NewHandlerFunc and the given options and
+// returns a client connected to it.
+func Connect(tb testing.TB, sub graphqlws.Subscriber, opts ...graphqlws.Option) *Client {
+	tb.Helper()
+
+	return NewServer(tb, graphqlws.NewHandlerFunc(sub, nil, opts...)).Dial(tb, nil)
+}
+
+// Client is a graphql-transport-ws client whose methods fail the test on
+// unexpected server behavior. A Client must be used from the test goroutine.
+type Client struct {
+	// Timeout bounds how long Read and the Expect methods wait for a message.
+	Timeout time.Duration
+
+	tb testing.TB
+	ws *websocket.Conn
+}
+
+// Conn returns the underlying WebSocket connection.
+func (c *Client) Conn() *websocket.Conn {
+	return c.ws
+}
+
+// Send writes msg to the server.
+func (c *Client) Send(msg Message) {
+	c.tb.Helper()
+
+	if err := c.ws.WriteJSON(msg); err != nil {
+		c.tb.Fatalf("graphqlwstest: failed to send %s message: %v", msg.Type, err)
+	}
+}
+
+// SendRaw writes data to the server as a text message, without validation.
+func (c *Client) SendRaw(data string) {
+	c.tb.Helper()
+
+	if err := c.ws.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+		c.tb.Fatalf("graphqlwstest: failed to send message: %v", err)
+	}
+}
+
+// Init sends connection_init with payload, which may be nil, and expects a
+// connection_ack. It returns the ack payload.
+func (c *Client) Init(payload any) json.RawMessage {
+	c.tb.Helper()
+
+	c.Send(Message{Type: "connection_init", Payload: c.marshal(payload)})
+	return c.Expect("", "connection_ack").Payload
+}
+
+// Subscribe starts an operation with the given id.
+func (c *Client) Subscribe(id string, query string, variables map[string]any) {
+	c.tb.Helper()
+
+	c.SubscribePayload(id, map[string]any{
+		"query":     query,
+		"variables": variables,
+	})
+}
+
+// SubscribePayload starts an operation with an arbitrary subscribe payload,
+// for example one carrying an operationName or extensions.
+func (c *Client) SubscribePayload(id string, payload any) {
+	c.tb.Helper()
+
+	c.Send(Message{ID: id, Type: "subscribe", Payload: c.marshal(payload)})
+}
+
+// Complete stops the operation with the given id.
+func (c *Client) Complete(id string) {
+	c.tb.Helper()
+
+	c.Send(Message{ID: id, Type: "complete"})
+}
+
+// Ping sends a ping with payload, which may be nil.
+func (c *Client) Ping(payload any) {
+	c.tb.Helper()
+
+	c.Send(Message{Type: "ping", Payload: c.marshal(payload)})
+}
+
+// Read returns the next message from the server.
+func (c *Client) Read() Message {
+	c.tb.Helper()
+
+	msg, err := c.read()
+	if err != nil {
+		c.tb.Fatalf("graphqlwstest: failed to read message: %v", err)
+	}
+
+	return msg
+}
+
+// Expect reads the next message and fails unless it has the given id and
+// type.
+func (c *Client) Expect(id string, typ string) Message {
+	c.tb.Helper()
+
+	msg := c.Read()
+	if msg.ID != id || msg.Type != typ {
+		c.tb.Fatalf("graphqlwstest: expected %s message for id %q, got %s message for id %q: %s", typ, id, msg.Type, msg.ID, msg.Payload)
+	}
+
+	return msg
+}
+
+// ExpectNext expects a next message for id and returns its payload.
+func (c *Client) ExpectNext(id string) json.RawMessage {
+	c.tb.Helper()
+
+	return c.Expect(id, "next").Payload
+}
+
+// ExpectError expects an error message for id and returns its payload.
+func (c *Client) ExpectError(id string) json.RawMessage {
+	c.tb.Helper()
+
+	return c.Expect(id, "error").Payload
+}
+
+// ExpectComplete expects a complete message for id.
+func (c *Client) ExpectComplete(id string) {
+	c.tb.Helper()
+
+	c.Expect(id, "complete")
+}
+
+// ExpectPong expects a pong message and returns its payload.
+func (c *Client) ExpectPong() json.RawMessage {
+	c.tb.Helper()
+
+	return c.Expect("", "pong").Payload
+}
+
+// ExpectClose expects the server to close the connection with code.
+func (c *Client) ExpectClose(code int) {
+	c.tb.Helper()
+
+	msg, err := c.read()
+	if err == nil {
+		c.tb.Fatalf("graphqlwstest: expected close %d, got %s message for id %q: %s", code, msg.Type, msg.ID, msg.Payload)
+	}
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		c.tb.Fatalf("graphqlwstest: expected close %d, got error: %v", code, err)
+	}
+
+	if closeErr.Code != code {
+		c.tb.Fatalf("graphqlwstest: expected close %d, got close %d (%s)", code, closeErr.Code, closeErr.Text)
+	}
+}
+
+// Close closes the connection without a close handshake.
+func (c *Client) Close() {
+	_ = c.ws.Close()
+}
+
+func (c *Client) read() (Message, error) {
+	if err := c.ws.SetReadDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return Message{}, err
+	}
+
+	var msg Message
+	err := c.ws.ReadJSON(&msg)
+	return msg, err
+}
+
+func (c *Client) marshal(payload any) json.RawMessage {
+	c.tb.Helper()
+
+	if payload == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		c.tb.Fatalf("graphqlwstest: failed to marshal payload: %v", err)
+	}
+
+	return b
+}
@@ -0,0 +1,144 @@
+package graphqlwstest_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	graphqlws "github.com/graph-gophers/graphql-transport-ws"
+	"github.com/graph-gophers/graphql-transport-ws/graphqlwstest"
+)
+
+type countSubscriber struct{}
+
+func (countSubscriber) Subscribe(ctx context.Context, document string, operation string, variables map[string]any) (<-chan any, error) {
+	n, _ := variables["count"].(float64)
+
+	c := make(chan any)
+	go func() {
+		defer close(c)
+		for i := 1; i <= int(n); i++ {
+			select {
+			case c <- map[string]any{"data": map[string]int{"n": i}}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	t.Run("subscription", func(t *testing.T) {
+		t.Parallel()
+
+		c := graphqlwstest.Connect(t, countSubscriber{})
+		c.Init(map[string]any{"token": "abc"})
+		c.Subscribe("1", "subscription { n }", map[string]any{"count": 2})
+
+		for i := 1; i <= 2; i++ {
+			if got, want := string(c.ExpectNext("1")), fmt.Sprintf(`{"data":{"n":%d}}`, i); got != want {
+				t.Fatalf("expected payload %s, got %s", want, got)
+			}
+		}
+		c.ExpectComplete("1")
+	})
+
+	t.Run("ping pong", func(t *testing.T) {
+		t.Parallel()
+
+		c := graphqlwstest.Connect(t, countSubscriber{})
+		c.Init(nil)
+		c.Ping(map[string]string{"hb": "1"})
+
+		if got := string(c.ExpectPong()); got != `{"hb":"1"}` {
+			t.Fatalf("unexpected pong payload %s", got)
+		}
+	})
+
+	t.Run("close code", func(t *testing.T) {
+		t.Parallel()
+
+		c := graphqlwstest.Connect(t, countSubscriber{})
+		c.Init(nil)
+		c.Send(graphqlwstest.Message{Type: "connection_init"})
+		c.ExpectClose(4429)
+	})
+
+	t.Run("options are applied", func(t *testing.T) {
+		t.Parallel()
+
+		c := graphqlwstest.Connect(t, countSubscriber{}, graphqlws.WithWriteTimeout(50*time.Millisecond))
+		c.ExpectClose(4408)
+	})
+
+	t.Run("multiple clients share a server", func(t *testing.T) {
+		t.Parallel()
+
+		srv := graphqlwstest.NewServer(t, graphqlws.NewHandlerFunc(countSubscriber{}, nil))
+		for i := 0; i < 3; i++ {
+			c := srv.Dial(t, nil)
+			c.Init(nil)
+			c.Subscribe("1", "subscription { n }", map[string]any{"count": 1})
+			c.ExpectNext("1")
+			c.ExpectComplete("1")
+		}
+	})
+}
+
+// recordingTB captures fatal failures instead of failing the enclosing test.
+type recordingTB struct {
+	testing.TB
+
+	mu       sync.Mutex
+	failures []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Fatalf(format string, args ...any) {
+	r.mu.Lock()
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+
+	runtime.Goexit()
+}
+
+func (r *recordingTB) run(fn func()) []string {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	<-done
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failures
+}
+
+func TestClientReportsFailures(t *testing.T) {
+	t.Parallel()
+
+	srv := graphqlwstest.NewServer(t, graphqlws.NewHandlerFunc(countSubscriber{}, nil))
+	rec := &recordingTB{TB: t}
+
+	failures := rec.run(func() {
+		c := srv.Dial(rec, nil)
+		c.Init(nil)
+		c.Subscribe("1", "subscription { n }", map[string]any{"count": 0})
+		c.ExpectNext("1")
+		t.Error("expected ExpectNext to stop the test goroutine")
+	})
+
+	if len(failures) != 1 || !strings.Contains(failures[0], "got complete message") {
+		t.Fatalf("expected a single failure about the complete message, got %v", failures)
+	}
+}
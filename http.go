@@ -2,6 +2,7 @@ package graphqlws
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"time"
 
@@ -68,9 +69,54 @@ type options struct {
 	checkOrigin       func(*http.Request) bool
 	maxOperations     int
 	hasMaxOperations  bool
+	replay            *replayBuffer
+	sessions          *sessionStore
+	sessionOwner      func(context.Context) string
+	compression       *compressionOptions
+	metrics           MetricsHooks
+	codec             Codec
+	subprotocols      []subprotocol
+	batch             *batchOptions
+	ratePolicies      map[string]RatePolicy
+
+	maxConnections       int
+	maxConnectionsPerKey int
+	connectionKey        func(*http.Request) string
+
+	messageRateLimit   *rateLimit
+	subscribeRateLimit *rateLimit
+	rateLimitAction    RateLimitAction
+
+	connectionQuota *OutputQuota
+	keyQuota        *outputQuota
+
+	schemaValidation bool
+	documents        *documentCache
+	operationLimits  *OperationLimits
+
+	allowIntrospection func(context.Context) bool
+
+	onSubscribe          OnSubscribeFunc
+	operationMaxDuration time.Duration
+	operationIdleTimeout time.Duration
+	timeoutAction        TimeoutAction
+
+	connectionAge connectionAge
+
+	initFunc    InitFunc
+	refreshFunc InitFunc
+
+	authorizer        Authorizer
+	authorizationHook func(context.Context, *AuthorizationRequest, error)
+
+	audit auditOptions
+
+	debug *DebugRegistry
+
+	profilerLabels bool
 }
 
-func (o *options) transportOptions() []transportOption {
+func (o *options) transportOptions(sub Subscriber) []transportOption {
 	var opts []transportOption
 
 	if o.hasReadLimit {
@@ -89,6 +135,87 @@ func (o *options) transportOptions() []transportOption {
 		opts = append(opts, transportMaxOperations(o.maxOperations))
 	}
 
+	if o.replay != nil {
+		opts = append(opts, transportReplay(o.replay))
+	}
+
+	if o.sessions != nil {
+		opts = append(opts, transportSessions(o.sessions))
+		if o.sessionOwner != nil {
+			opts = append(opts, transportSessionOwner(o.sessionOwner))
+		}
+	}
+
+	if o.codec != nil {
+		opts = append(opts, transportCodec(o.codec))
+	}
+
+	if o.batch != nil {
+		opts = append(opts, transportWriteBatching(*o.batch))
+	}
+
+	if o.ratePolicies != nil {
+		opts = append(opts, transportRatePolicies(o.ratePolicies))
+	}
+
+	if o.messageRateLimit != nil {
+		opts = append(opts, transportMessageRateLimit(*o.messageRateLimit))
+	}
+
+	if o.subscribeRateLimit != nil {
+		opts = append(opts, transportSubscribeRateLimit(*o.subscribeRateLimit))
+	}
+
+	if o.rateLimitAction != RateLimitClose {
+		opts = append(opts, transportRateLimitAction(o.rateLimitAction))
+	}
+
+	if o.connectionQuota != nil || o.keyQuota != nil {
+		opts = append(opts, transportOutputQuotas(o.connectionQuota, o.keyQuota, o.metrics.OnOutputQuotaExceeded))
+	}
+
+	if o.onSubscribe != nil {
+		opts = append(opts, transportOnSubscribe(o.onSubscribe))
+	}
+
+	if o.operationMaxDuration > 0 || o.operationIdleTimeout > 0 || o.timeoutAction != TimeoutComplete {
+		opts = append(opts, transportOperationTimeouts(o.operationMaxDuration, o.operationIdleTimeout, o.timeoutAction))
+	}
+
+	if o.connectionAge.age > 0 {
+		opts = append(opts, transportMaxConnectionAge(o.connectionAge))
+	}
+
+	if o.initFunc != nil || o.refreshFunc != nil {
+		opts = append(opts, transportAuth(o.initFunc, o.refreshFunc))
+	}
+
+	if o.authorizer != nil {
+		opts = append(opts, transportAuthorizer(o.authorizer, o.authorizationHook, schemaOf(sub)))
+	}
+
+	if o.audit.sink != nil {
+		opts = append(opts, transportAudit(o.audit))
+	}
+
+	if o.debug != nil {
+		opts = append(opts, transportDebug(o.debug))
+	}
+
+	if o.profilerLabels {
+		opts = append(opts, transportProfilerLabels())
+	}
+
+	if o.documents != nil {
+		opts = append(opts, transportDocuments(o.documents))
+	}
+
+	v := &documentValidator{schema: schemaOf(sub), limits: o.operationLimits, allowIntrospection: o.allowIntrospection}
+	v.validateSchema = o.schemaValidation && v.schema != nil
+	if v.validateSchema || v.limits != nil || v.allowIntrospection != nil {
+		opts = append(opts, transportValidation(v))
+	}
+
 	return opts
 }
 
@@ -157,6 +284,70 @@ func WithMaxSubscriptions(n int) Option {
 	})
 }
 
+// WithReplayBuffer retains the last size next payloads of every subscription
+// so that clients can resume after a reconnect. A subscription is identified
+// by its document, operation name, variables and the user returned by user
+// for the connection context. Every next payload is stamped with an opaque
+// cursor in extensions.cursor; a client that subscribes again with that
+// cursor in extensions.resumeFrom is sent the retained events it missed
+// before live delivery continues.
+//
+// Operations with the same identity share a single upstream subscription,
+// which records every event once. It is started with the context of the
+// first operation, without its cancellation, so it must not depend on
+// context values the user function does not capture. After the last
+// operation of an identity ends, its subscription keeps running and
+// recording for the retention period, so that a client that reconnects
+// within it can resume without losing events. An operation that falls more
+// than 16 events behind is ended with an error, after which its client can
+// resume from the last cursor it received.
+//
+// Streams that are not subscribed to and record no event for the retention
+// period are discarded. Pass 0 to keep them for the lifetime of the handler;
+// subscriptions then end with their last operation, so only events produced
+// while a client is subscribed can be resumed from. A nil user function
+// shares streams between all connections and must only be used for public
+// data.
+func WithReplayBuffer(size int, retention time.Duration, user func(context.Context) string) Option {
+	b := newReplayBuffer(size, retention, user)
+	return optionFunc(func(o *options) {
+		o.replay = b
+	})
+}
+
+// WithSessionResumption lets connections survive brief network interruptions.
+// The server issues a session token in the connection_ack payload
+// ({"sessionToken": "..."}). When the socket drops without a close frame
+// from the client, active operations keep running and their messages are
+// buffered for up to grace. A client that reconnects within grace and sends
+// the token in its connection_init payload is re-attached to its operations
+// and receives the buffered messages. Otherwise a new session with a new
+// token is started and the client must resubscribe.
+//
+// At most maxBuffered messages are kept per detached session; a session that
+// exceeds the limit is ended. Messages already handed to the socket when it
+// dropped are not retransmitted.
+//
+// Resumed operations keep running with the context of the connection that
+// created the session, not that of the resuming connection. Without
+// WithSessionOwner, the session token is a bearer credential for them.
+func WithSessionResumption(grace time.Duration, maxBuffered int) Option {
+	st := newSessionStore(grace, maxBuffered)
+	return optionFunc(func(o *options) {
+		o.sessions = st
+	})
+}
+
+// WithSessionOwner binds sessions to the identity owner derives from the
+// connection context, such as the user set by the InitFunc. A connection only
+// resumes a session created by a connection with the same identity; otherwise
+// it starts a new session.
+func WithSessionOwner(owner func(ctx context.Context) string) Option {
+	return optionFunc(func(o *options) {
+		o.sessionOwner = owner
+	})
+}
+
 func applyOptions(opts ...Option) *options {
 	var o options
 
@@ -164,6 +355,13 @@ func applyOptions(opts ...Option) *options {
 		op.apply(&o)
 	}
 
+	// Parsed documents are cached for every feature that needs them, unless
+	// WithSchemaValidation disabled the cache.
+	needsDocuments := o.operationLimits != nil || o.allowIntrospection != nil || o.authorizer != nil || o.connectionAge.age > 0
+	if o.documents == nil && !o.schemaValidation && needsDocuments {
+		o.documents = newDocumentCache(defaultDocumentCacheSize)
+	}
+
 	return &o
 }
 
@@ -181,6 +379,18 @@ func (h *Handler) NewHandlerFunc(svc Subscriber, httpHandler http.Handler, optio
 	if o.checkOrigin != nil {
 		upgrader.CheckOrigin = o.checkOrigin
 	}
+	if o.compression != nil {
+		upgrader.EnableCompression = true
+	}
+	if len(o.subprotocols) > 0 {
+		var names []string
+		for _, p := range o.subprotocols {
+			names = append(names, p.name)
+		}
+		upgrader.Subprotocols = append(names, upgrader.Subprotocols...)
+	}
+
+	limiter := newConnectionLimiter(o)
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !websocket.IsWebSocketUpgrade(r) {
@@ -193,29 +403,83 @@ func (h *Handler) NewHandlerFunc(svc Subscriber, httpHandler http.Handler, optio
 			return
 		}
 
+		release := func() {}
+		if limiter != nil {
+			var status int
+			if release, status = limiter.acquire(r); status != 0 {
+				if o.metrics.OnConnectionRejected != nil {
+					o.metrics.OnConnectionRejected(status)
+				}
+				rejectConnection(w, status)
+				return
+			}
+		}
+
 		ctx, err := buildContext(r, o.contextGenerators)
 		if err != nil {
 			// Preserve diagnostic information for failed context setup
 			// while returning a generic 403 response code
 			w.Header().Set("X-WebSocket-Upgrade-Failure", "context setup failed")
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			release()
 			return
 		}
 
+		compress := o.compression != nil && offersCompression(r)
+
+		var (
+			wire  *countingConn
+			batch *batchConn
+		)
+		count := compress && o.metrics.OnCompression != nil
+		batching := o.batch != nil && o.batch.maxSize > 1
+		if count || batching {
+			w = &hijackResponseWriter{ResponseWriter: w, wrap: func(c net.Conn) net.Conn {
+				if batching {
+					batch = &batchConn{Conn: c}
+					c = batch
+				}
+				if count {
+					// Counted above the batch, so that every message is
+					// measured when it is written.
+					wire = &countingConn{Conn: c}
+					c = wire
+				}
+				return c
+			}}
+		}
+
 		ws, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			// UPGRADE FAILED: The Upgrader has already written an error response.
 			// Do not call the httpHandler
+			release()
 			return
 		}
 
-		switch ws.Subprotocol() {
-		case ProtocolGraphQLTransportWS:
-			go connectTransport(ctx, ws, svc, o.transportOptions()...)
+		codec, ok := o.subprotocolCodec(ws.Subprotocol())
+		switch {
+		case ok:
+			conn := &gorillaConn{ws: ws, batch: batch}
+			if compress {
+				_ = ws.SetCompressionLevel(o.compression.level)
+				conn.compress = true
+				conn.compressMinSize = o.compression.minSize
+			}
+			if wire != nil {
+				conn.wire = wire
+				conn.onCompression = o.metrics.OnCompression
+			}
+
+			go func() {
+				defer release()
+				connectTransport(ctx, conn, svc, append(o.transportOptions(svc), transportCodec(codec))...)
+			}()
 
 		default:
 			w.Header().Set("X-WebSocket-Upgrade-Failure", "unsupported subprotocol")
 			ws.Close()
+			release()
 		}
 	}
 }
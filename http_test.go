@@ -1,9 +1,11 @@
 package graphqlws_test
 
 import (
+	"compress/flate"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -384,3 +386,354 @@ func requireConnectionAck(t *testing.T, conn *websocket.Conn) {
 		t.Fatalf("expected connection_ack message, got %q", msg.Type)
 	}
 }
+
+func TestWithCompression(t *testing.T) {
+	t.Parallel()
+
+	payload := map[string]string{"data": strings.Repeat("subscription payload ", 100)}
+
+	type report struct{ uncompressed, compressed int }
+
+	dial := func(t *testing.T, enableCompression bool) (*websocket.Conn, chan report) {
+		t.Helper()
+
+		reports := make(chan report, 10)
+		mockSvc := &fakeGraphQLService{
+			subscribeFn: func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+				c := make(chan any, 1)
+				c <- payload
+				close(c)
+				return c, nil
+			},
+		}
+
+		handler := graphqlws.NewHandlerFunc(
+			mockSvc,
+			nil,
+			graphqlws.WithCompression(flate.BestCompression, 256),
+			graphqlws.WithMetricsHooks(graphqlws.MetricsHooks{
+				OnCompression: func(uncompressed, compressed int) {
+					reports <- report{uncompressed, compressed}
+				},
+			}),
+		)
+
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+		dialer := websocket.Dialer{
+			Subprotocols:      []string{graphqlws.ProtocolGraphQLTransportWS},
+			EnableCompression: enableCompression,
+		}
+		conn, _, err := dialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("websocket dial failed: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		requireConnectionAck(t, conn)
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","type":"subscribe","payload":{"query":"subscription{}"}}`)); err != nil {
+			t.Fatalf("failed to write subscribe: %v", err)
+		}
+
+		var msg struct {
+			Type    string            `json:"type"`
+			Payload map[string]string `json:"payload"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("failed to read next: %v", err)
+		}
+		if msg.Type != "next" || msg.Payload["data"] != payload["data"] {
+			t.Fatalf("unexpected message: %+v", msg)
+		}
+
+		return conn, reports
+	}
+
+	t.Run("compresses large messages", func(t *testing.T) {
+		t.Parallel()
+
+		_, reports := dial(t, true)
+
+		select {
+		case r := <-reports:
+			if r.uncompressed < 2000 {
+				t.Fatalf("expected the next message to be reported, got %d bytes", r.uncompressed)
+			}
+			if r.compressed <= 0 || r.compressed >= r.uncompressed/4 {
+				t.Fatalf("expected repetitive payload to compress well, got %d of %d bytes", r.compressed, r.uncompressed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for compression report")
+		}
+
+		// connection_ack and complete are below the threshold.
+		select {
+		case r := <-reports:
+			t.Fatalf("unexpected compression report for a small message: %+v", r)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("client without compression", func(t *testing.T) {
+		t.Parallel()
+
+		_, reports := dial(t, false)
+
+		select {
+		case r := <-reports:
+			t.Fatalf("unexpected compression report: %+v", r)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+func TestPreEncodedSharedFrames(t *testing.T) {
+	t.Parallel()
+
+	event := graphqlws.NewPreEncoded(map[string]string{"data": strings.Repeat("shared ", 100)})
+	mockSvc := &fakeGraphQLService{
+		subscribeFn: func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any, 1)
+			c <- event
+			close(c)
+			return c, nil
+		},
+	}
+
+	server := httptest.NewServer(graphqlws.NewHandlerFunc(mockSvc, nil, graphqlws.WithCompression(flate.BestSpeed, 256)))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	for _, enableCompression := range []bool{true, false, true} {
+		dialer := websocket.Dialer{
+			Subprotocols:      []string{graphqlws.ProtocolGraphQLTransportWS},
+			EnableCompression: enableCompression,
+		}
+		conn, _, err := dialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("websocket dial failed: %v", err)
+		}
+		defer conn.Close()
+
+		requireConnectionAck(t, conn)
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","type":"subscribe","payload":{"query":"subscription{}"}}`)); err != nil {
+			t.Fatalf("failed to write subscribe: %v", err)
+		}
+
+		var msg struct {
+			ID      string            `json:"id"`
+			Type    string            `json:"type"`
+			Payload map[string]string `json:"payload"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("failed to read next: %v", err)
+		}
+		if msg.ID != "1" || msg.Type != "next" || msg.Payload["data"] != strings.Repeat("shared ", 100) {
+			t.Fatalf("unexpected message: %+v", msg)
+		}
+	}
+}
+
+func TestWithWriteBatching(t *testing.T) {
+	t.Parallel()
+
+	const events = 50
+
+	mockSvc := &fakeGraphQLService{
+		subscribeFn: func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any, events)
+			for i := range events {
+				c <- map[string]any{"data": map[string]any{"n": i, "pad": strings.Repeat("x", i*10)}}
+			}
+			close(c)
+			return c, nil
+		},
+	}
+
+	server := httptest.NewServer(graphqlws.NewHandlerFunc(mockSvc, nil,
+		graphqlws.WithWriteBatching(8, time.Millisecond),
+		graphqlws.WithCompression(flate.BestSpeed, 128),
+	))
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{graphqlws.ProtocolGraphQLTransportWS}, EnableCompression: true}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("websocket dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	requireConnectionAck(t, conn)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","type":"subscribe","payload":{"query":"subscription{}"}}`)); err != nil {
+		t.Fatalf("failed to write subscribe: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := range events {
+		var msg struct {
+			Type    string `json:"type"`
+			Payload struct {
+				Data struct {
+					N int `json:"n"`
+				} `json:"data"`
+			} `json:"payload"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("failed to read message %d: %v", i, err)
+		}
+		if msg.Type != "next" || msg.Payload.Data.N != i {
+			t.Fatalf("expected next %d, got %+v", i, msg)
+		}
+	}
+
+	var complete struct {
+		Type string `json:"type"`
+	}
+	if err := conn.ReadJSON(&complete); err != nil || complete.Type != "complete" {
+		t.Fatalf("expected complete, got %+v (%v)", complete, err)
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	t.Parallel()
+
+	type dial struct {
+		user       string
+		closeFirst bool // close the oldest open connection before dialing
+		wantStatus int
+	}
+
+	testTable := map[string]struct {
+		opts  []graphqlws.Option
+		dials []dial
+	}{
+		"max connections": {
+			opts: []graphqlws.Option{graphqlws.WithMaxConnections(2)},
+			dials: []dial{
+				{wantStatus: http.StatusSwitchingProtocols},
+				{wantStatus: http.StatusSwitchingProtocols},
+				{wantStatus: http.StatusServiceUnavailable},
+				{closeFirst: true, wantStatus: http.StatusSwitchingProtocols},
+			},
+		},
+		"max connections per remote IP": {
+			opts: []graphqlws.Option{graphqlws.WithMaxConnectionsPerKey(1)},
+			dials: []dial{
+				{user: "a", wantStatus: http.StatusSwitchingProtocols},
+				{user: "b", wantStatus: http.StatusTooManyRequests},
+			},
+		},
+		"max connections per key": {
+			opts: []graphqlws.Option{
+				graphqlws.WithMaxConnectionsPerKey(1),
+				graphqlws.WithConnectionKey(func(r *http.Request) string { return r.Header.Get("X-User") }),
+			},
+			dials: []dial{
+				{user: "a", wantStatus: http.StatusSwitchingProtocols},
+				{user: "b", wantStatus: http.StatusSwitchingProtocols},
+				{user: "a", wantStatus: http.StatusTooManyRequests},
+				{wantStatus: http.StatusSwitchingProtocols},
+				{user: "a", closeFirst: true, wantStatus: http.StatusSwitchingProtocols},
+			},
+		},
+		"global limit before key limit": {
+			opts: []graphqlws.Option{
+				graphqlws.WithMaxConnections(1),
+				graphqlws.WithMaxConnectionsPerKey(1),
+			},
+			dials: []dial{
+				{wantStatus: http.StatusSwitchingProtocols},
+				{wantStatus: http.StatusServiceUnavailable},
+			},
+		},
+	}
+
+	for name, tt := range testTable {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu       sync.Mutex
+				rejected []int
+			)
+			opts := append(tt.opts, graphqlws.WithMetricsHooks(graphqlws.MetricsHooks{
+				OnConnectionRejected: func(status int) {
+					mu.Lock()
+					rejected = append(rejected, status)
+					mu.Unlock()
+				},
+			}))
+
+			server := httptest.NewServer(graphqlws.NewHandlerFunc(&fakeGraphQLService{}, nil, opts...))
+			defer server.Close()
+
+			var (
+				open         []*websocket.Conn
+				wantRejected []int
+			)
+			defer func() {
+				for _, conn := range open {
+					conn.Close()
+				}
+			}()
+
+			for i, d := range tt.dials {
+				if d.closeFirst {
+					open[0].Close()
+					open = open[1:]
+				}
+
+				header := http.Header{}
+				if d.user != "" {
+					header.Set("X-User", d.user)
+				}
+
+				// The slot of a closed connection is released once the
+				// server notices, so retry briefly.
+				var (
+					conn *websocket.Conn
+					resp *http.Response
+					err  error
+				)
+				deadline := time.Now().Add(time.Second)
+				for {
+					dialer := websocket.Dialer{Subprotocols: []string{graphqlws.ProtocolGraphQLTransportWS}}
+					conn, resp, err = dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+					if !d.closeFirst || err == nil || time.Now().After(deadline) {
+						break
+					}
+					if resp != nil {
+						wantRejected = append(wantRejected, resp.StatusCode)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				if resp == nil {
+					t.Fatalf("dial %d: no response: %v", i, err)
+				}
+				if resp.StatusCode != d.wantStatus {
+					t.Fatalf("dial %d: want status %d, got %d", i, d.wantStatus, resp.StatusCode)
+				}
+
+				if err == nil {
+					requireConnectionAck(t, conn)
+					open = append(open, conn)
+					continue
+				}
+
+				if got := resp.Header.Get("Retry-After"); got == "" {
+					t.Fatalf("dial %d: expected a Retry-After header", i)
+				}
+				wantRejected = append(wantRejected, d.wantStatus)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if fmt.Sprint(rejected) != fmt.Sprint(wantRejected) {
+				t.Fatalf("want rejections %v, got %v", wantRejected, rejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package graphqlws
+
+import (
+	"context"
+
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// errorCodeIntrospectionDisabled is the extensions.code of the error
+// rejecting operations with introspection fields.
+const errorCodeIntrospectionDisabled = "INTROSPECTION_DISABLED"
+
+// WithRestrictIntrospection rejects subscribe operations selecting the
+// __schema or __type introspection fields, like graphql-go's
+// RestrictIntrospection does for requests it executes itself. Operations
+// are rejected with an error message with extensions.code
+// INTROSPECTION_DISABLED before they are passed to the Subscriber.
+//
+// allow is called with the operation context, which carries the values of
+// the connection context, and may permit introspection for some clients,
+// such as internal users. A nil allow disables introspection for everyone.
+func WithRestrictIntrospection(allow func(ctx context.Context) bool) Option {
+	if allow == nil {
+		allow = func(context.Context) bool { return false }
+	}
+
+	return optionFunc(func(o *options) {
+		o.allowIntrospection = allow
+	})
+}
+
+// checkIntrospection returns an error if op selects an introspection field
+// and the client is not allowed to.
+func (v *documentValidator) checkIntrospection(ctx context.Context, doc *document, op *operationDefinition) []*gqlerrors.QueryError {
+	field := doc.introspectionField(op.selections, make(map[string]bool))
+	if field == nil || v.allowIntrospection(ctx) {
+		return nil
+	}
+
+	err := gqlerrors.Errorf("introspection is disabled")
+	err.Locations = []gqlerrors.Location{field.loc}
+	err.Extensions = map[string]any{"code": errorCodeIntrospectionDisabled}
+	return []*gqlerrors.QueryError{err}
+}
+
+// introspectionField returns the first __schema or __type field of
+// selections, including those of fragments, or nil.
+func (d *document) introspectionField(selections []*selection, visited map[string]bool) *selection {
+	for _, s := range selections {
+		switch {
+		case s.field == "__schema" || s.field == "__type":
+			return s
+
+		case s.fragment != "":
+			f, ok := d.fragments[s.fragment]
+			if !ok || visited[s.fragment] {
+				continue
+			}
+			visited[s.fragment] = true
+			if field := d.introspectionField(f.selections, visited); field != nil {
+				return field
+			}
+
+		default:
+			if field := d.introspectionField(s.selections, visited); field != nil {
+				return field
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestRestrictIntrospection(t *testing.T) {
+	t.Parallel()
+
+	type internalKey struct{}
+	allow := func(ctx context.Context) bool {
+		internal, _ := ctx.Value(internalKey{}).(bool)
+		return internal
+	}
+
+	testTable := map[string]struct {
+		query    string
+		internal bool
+		want     string // the error payload, if any
+	}{
+		"schema": {
+			query: `{\n  __schema { types { name } }\n}`,
+			want:  `[{"message":"introspection is disabled","locations":[{"line":2,"column":3}],"extensions":{"code":"INTROSPECTION_DISABLED"}}]`,
+		},
+		"type in fragment": {
+			query: `query { ...A } fragment A on Query { ... on Query { __type(name: \"Query\") { name } } }`,
+			want:  `[{"message":"introspection is disabled","locations":[{"line":1,"column":53}],"extensions":{"code":"INTROSPECTION_DISABLED"}}]`,
+		},
+		"typename": {
+			query: `subscription { a { __typename } }`,
+		},
+		"allowed by predicate": {
+			query:    `{ __schema { queryType { name } } }`,
+			internal: true,
+		},
+	}
+
+	for name, tt := range testTable {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := setupTest(t)
+			ctx := context.WithValue(context.Background(), internalKey{}, tt.internal)
+			go connectTransport(ctx, h.conn, h.mockSvc, transportValidation(&documentValidator{allowIntrospection: allow}))
+			defer close(h.conn.in)
+
+			h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+			h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"` + tt.query + `"}}`)
+			requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+			msg := requireMessage(t, h.conn)
+			if tt.want == "" {
+				requireMessageType(t, msg, "complete")
+				return
+			}
+
+			requireEqualJSON(t, `{"id":"1","type":"error","payload":`+tt.want+`}`, msg, "")
+			if calls := h.mockSvc.getCalls(); len(calls) != 0 {
+				t.Fatalf("expected the operation not to be subscribed, got %d calls", len(calls))
+			}
+		})
+	}
+}
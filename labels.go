@@ -0,0 +1,65 @@
+package graphqlws
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// The runtime/pprof labels set by WithProfilerLabels.
+const (
+	labelConnection    = "graphqlws.connection"
+	labelOperation     = "graphqlws.operation"
+	labelOperationName = "graphqlws.operation_name"
+)
+
+// WithProfilerLabels runs the goroutines of every connection under
+// runtime/pprof labels, so that goroutine and CPU profiles can be attributed
+// to clients and operations. The read and write goroutines of a connection
+// are labeled with graphqlws.connection, its ID in audit records and the
+// DebugRegistry. The goroutine of an operation is additionally labeled with
+// graphqlws.operation and, if the operation is named,
+// graphqlws.operation_name.
+//
+// The labels are carried by the context passed to Subscribe. Goroutines
+// started by the Subscriber inherit them; goroutines started elsewhere can
+// apply them with pprof.SetGoroutineLabels(ctx).
+func WithProfilerLabels() Option {
+	return optionFunc(func(o *options) {
+		o.profilerLabels = true
+	})
+}
+
+func transportProfilerLabels() transportOption {
+	return func(conn *connection) {
+		conn.identify()
+		conn.profilerLabels = true
+	}
+}
+
+// run calls f, with ctx labeled with the connection ID when profiler labels
+// are enabled. Goroutines started by f inherit the labels.
+func (conn *connection) run(ctx context.Context, f func(ctx context.Context)) {
+	if !conn.profilerLabels {
+		f(ctx)
+		return
+	}
+
+	pprof.Do(ctx, pprof.Labels(labelConnection, conn.id), f)
+}
+
+// runOperation runs the operation id, labeled with its ID and name when
+// profiler labels are enabled.
+func (conn *connection) runOperation(ctx context.Context, id string, payload subscribeMessagePayload, send sendFunc, ops operationMap) {
+	if !conn.profilerLabels {
+		conn.runSubscription(ctx, id, payload, send, ops)
+		return
+	}
+
+	labels := pprof.Labels(labelOperation, id)
+	if payload.OperationName != "" {
+		labels = pprof.Labels(labelOperation, id, labelOperationName, payload.OperationName)
+	}
+	pprof.Do(ctx, labels, func(ctx context.Context) {
+		conn.runSubscription(ctx, id, payload, send, ops)
+	})
+}
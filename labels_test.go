@@ -0,0 +1,60 @@
+package graphqlws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+func TestProfilerLabels(t *testing.T) {
+	t.Parallel()
+
+	labels := make(chan map[string]string, 1)
+	h := setupTest(t)
+	h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+		got := make(map[string]string)
+		pprof.ForLabels(ctx, func(key, value string) bool {
+			got[key] = value
+			return true
+		})
+		labels <- got
+
+		// A resolver goroutine, which inherits the labels.
+		c := make(chan any)
+		go func() {
+			<-ctx.Done()
+			close(c)
+		}()
+		return c, nil
+	}
+
+	go connectTransport(context.Background(), h.conn, h.mockSvc, transportProfilerLabels())
+	defer close(h.conn.in)
+
+	h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+	requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+	h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription ProfilerLabels { a }","operationName":"ProfilerLabels"}}`)
+
+	got := <-labels
+	if len(got) != 3 || got[labelConnection] == "" || got[labelOperation] != "1" || got[labelOperationName] != "ProfilerLabels" {
+		t.Fatalf("unexpected labels %v", got)
+	}
+
+	var profile bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&profile, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"` + labelOperationName + `":"ProfilerLabels"`,
+		`"` + labelConnection + `":"` + got[labelConnection] + `"`,
+	} {
+		if !strings.Contains(profile.String(), want) {
+			t.Fatalf("want goroutines labeled %s", want)
+		}
+	}
+
+	h.conn.in <- json.RawMessage(`{"id":"1","type":"complete"}`)
+}
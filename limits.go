@@ -0,0 +1,130 @@
+package graphqlws
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// connectionRetryAfter is the Retry-After value, in seconds, of responses
+// rejecting a connection over a limit.
+const connectionRetryAfter = 5
+
+// WithMaxConnections limits the number of concurrent WebSocket connections of
+// the handler. Connections over the limit are rejected before the upgrade with
+// 503 Service Unavailable and a Retry-After header. Pass 0 to disable the
+// limit, which is the default.
+func WithMaxConnections(n int) Option {
+	return optionFunc(func(o *options) {
+		o.maxConnections = max(n, 0)
+	})
+}
+
+// WithMaxConnectionsPerKey limits the number of concurrent WebSocket
+// connections per connection key, the remote IP address unless set with
+// WithConnectionKey. Connections over the limit are rejected before the
+// upgrade with 429 Too Many Requests and a Retry-After header. Pass 0 to
+// disable the limit, which is the default.
+func WithMaxConnectionsPerKey(n int) Option {
+	return optionFunc(func(o *options) {
+		o.maxConnectionsPerKey = max(n, 0)
+	})
+}
+
+// WithConnectionKey derives the key that WithMaxConnectionsPerKey limits
+// connections by from the upgrade request, for example from the
+// X-Forwarded-For header set by a trusted proxy or from the authenticated
+// user. Requests for which key returns "" are only subject to
+// WithMaxConnections.
+func WithConnectionKey(key func(*http.Request) string) Option {
+	return optionFunc(func(o *options) {
+		o.connectionKey = key
+	})
+}
+
+// remoteIP returns the IP address of the client of r.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// connectionLimiter counts the connections of a handler.
+type connectionLimiter struct {
+	max       int
+	maxPerKey int
+	key       func(*http.Request) string
+
+	mu    sync.Mutex
+	total int
+	byKey map[string]int
+}
+
+func newConnectionLimiter(o *options) *connectionLimiter {
+	if o.maxConnections == 0 && o.maxConnectionsPerKey == 0 {
+		return nil
+	}
+
+	key := o.connectionKey
+	if key == nil {
+		key = remoteIP
+	}
+
+	return &connectionLimiter{
+		max:       o.maxConnections,
+		maxPerKey: o.maxConnectionsPerKey,
+		key:       key,
+		byKey:     make(map[string]int),
+	}
+}
+
+// acquire counts a connection for r. If a limit is reached it returns the
+// status to reject the connection with instead. Otherwise the returned
+// release function must be called when the connection ends.
+func (l *connectionLimiter) acquire(r *http.Request) (release func(), status int) {
+	var key string
+	if l.maxPerKey > 0 {
+		key = l.key(r)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.total >= l.max {
+		return nil, http.StatusServiceUnavailable
+	}
+	if key != "" && l.byKey[key] >= l.maxPerKey {
+		return nil, http.StatusTooManyRequests
+	}
+
+	l.total++
+	if key != "" {
+		l.byKey[key]++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.total--
+			if key != "" {
+				if l.byKey[key]--; l.byKey[key] == 0 {
+					delete(l.byKey, key)
+				}
+			}
+		})
+	}, 0
+}
+
+// rejectConnection responds to an upgrade request over a connection limit.
+func rejectConnection(w http.ResponseWriter, status int) {
+	w.Header().Set("Retry-After", strconv.Itoa(connectionRetryAfter))
+	w.Header().Set("X-WebSocket-Upgrade-Failure", "too many connections")
+	http.Error(w, http.StatusText(status), status)
+}
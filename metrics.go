@@ -0,0 +1,30 @@
+package graphqlws
+
+// MetricsHooks receives measurements from the handler. Nil hooks are not
+// called. Hooks are called synchronously from connection goroutines and should
+// return quickly.
+type MetricsHooks struct {
+	// OnCompression is called for every message written with per-message
+	// compression, with the size of the message and the number of bytes
+	// written to the network for it, including framing. The compression
+	// ratio is compressed / uncompressed.
+	OnCompression func(uncompressed, compressed int)
+
+	// OnConnectionRejected is called for every upgrade request rejected
+	// because of a connection limit, with the status of the response:
+	// http.StatusServiceUnavailable for WithMaxConnections and
+	// http.StatusTooManyRequests for WithMaxConnectionsPerKey.
+	OnConnectionRejected func(status int)
+
+	// OnOutputQuotaExceeded is called for every operation ended because it
+	// exceeded an output quota, with the key of the quota set with
+	// WithKeyOutputQuota, or "" for WithConnectionOutputQuota.
+	OnOutputQuotaExceeded func(key string)
+}
+
+// WithMetricsHooks reports handler measurements to hooks.
+func WithMetricsHooks(hooks MetricsHooks) Option {
+	return optionFunc(func(o *options) {
+		o.metrics = hooks
+	})
+}
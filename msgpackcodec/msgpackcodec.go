@@ -0,0 +1,123 @@
+// Package msgpackcodec provides a MessagePack flavour of graphql-transport-ws
+// carried in binary frames.
+//
+// Messages have the same structure as in graphql-transport-ws, a map with the
+// keys id, type and payload, encoded as MessagePack:
+//
+//	handler := graphqlws.NewHandlerFunc(schema, h,
+//		graphqlws.WithSubprotocol(msgpackcodec.Subprotocol, msgpackcodec.Codec{}),
+//	)
+package msgpackcodec
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	graphqlws "github.com/graph-gophers/graphql-transport-ws"
+)
+
+// Subprotocol is the WebSocket subprotocol name clients request to use Codec.
+const Subprotocol = "graphql-transport-ws+msgpack"
+
+// Codec encodes messages as MessagePack.
+//
+// Payload values are converted through their JSON representation, so json
+// struct tags and json.Marshaler implementations, such as the json.RawMessage
+// data of graphql-go responses, are honored, and decoded numbers are float64
+// as with encoding/json. Binary MessagePack values in client payloads are
+// decoded as base64 strings.
+type Codec struct{}
+
+var _ graphqlws.Codec = Codec{}
+
+type message struct {
+	ID      string             `msgpack:"id,omitempty"`
+	Type    string             `msgpack:"type"`
+	Payload msgpack.RawMessage `msgpack:"payload,omitempty"`
+}
+
+// Name returns "msgpack".
+func (Codec) Name() string {
+	return "msgpack"
+}
+
+// MessageType returns graphqlws.BinaryMessage.
+func (Codec) MessageType() graphqlws.MessageType {
+	return graphqlws.BinaryMessage
+}
+
+// Marshal encodes v as MessagePack.
+func (Codec) Marshal(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	return msgpack.Marshal(fromJSON(generic))
+}
+
+// Unmarshal decodes MessagePack data into v.
+func (Codec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.UseLooseInterfaceDecoding(true)
+
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// EncodeMessage embeds payload, which is already MessagePack, as is.
+func (Codec) EncodeMessage(id string, typ string, payload []byte) ([]byte, error) {
+	return msgpack.Marshal(message{ID: id, Type: typ, Payload: payload})
+}
+
+// DecodeMessage decodes a MessagePack message.
+func (Codec) DecodeMessage(data []byte) (string, string, []byte, error) {
+	var msg message
+	if err := msgpack.Unmarshal(data, &msg); err != nil {
+		return "", "", nil, err
+	}
+
+	return msg.ID, msg.Type, msg.Payload, nil
+}
+
+// fromJSON converts the json.Number values of a decoded JSON document to
+// integers where possible, so that they are encoded compactly.
+func fromJSON(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = fromJSON(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = fromJSON(e)
+		}
+	}
+
+	return v
+}
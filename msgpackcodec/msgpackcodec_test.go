@@ -0,0 +1,220 @@
+package msgpackcodec_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/vmihailenco/msgpack/v5"
+
+	graphqlws "github.com/graph-gophers/graphql-transport-ws"
+	"github.com/graph-gophers/graphql-transport-ws/msgpackcodec"
+)
+
+const schemaSDL = `
+	schema {
+		query: Query
+		subscription: Subscription
+	}
+
+	type Query {
+		hello: String!
+	}
+
+	type Subscription {
+		count(upTo: Int!): Int!
+	}
+`
+
+type resolver struct{}
+
+func (*resolver) Hello() string { return "hello" }
+
+func (*resolver) Count(ctx context.Context, args struct{ UpTo int32 }) <-chan int32 {
+	c := make(chan int32)
+	go func() {
+		defer close(c)
+		for i := int32(1); i <= args.UpTo; i++ {
+			select {
+			case c <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c
+}
+
+type message struct {
+	ID      string `msgpack:"id,omitempty"`
+	Type    string `msgpack:"type"`
+	Payload struct {
+		Data struct {
+			Count int `msgpack:"count"`
+		} `msgpack:"data"`
+	} `msgpack:"payload,omitempty"`
+}
+
+func TestSubprotocol(t *testing.T) {
+	t.Parallel()
+
+	schema := graphql.MustParseSchema(schemaSDL, &resolver{})
+	srv := httptest.NewServer(graphqlws.NewHandlerFunc(schema, nil,
+		graphqlws.WithSubprotocol(msgpackcodec.Subprotocol, msgpackcodec.Codec{}),
+	))
+	t.Cleanup(srv.Close)
+
+	dialer := websocket.Dialer{Subprotocols: []string{msgpackcodec.Subprotocol, graphqlws.ProtocolGraphQLTransportWS}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	if ws.Subprotocol() != msgpackcodec.Subprotocol {
+		t.Fatalf("expected subprotocol %q, got %q", msgpackcodec.Subprotocol, ws.Subprotocol())
+	}
+
+	send := func(msg map[string]any) {
+		t.Helper()
+
+		b, err := msgpack.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	read := func() message {
+		t.Helper()
+
+		_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+		typ, b, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if typ != websocket.BinaryMessage {
+			t.Fatalf("expected a binary frame, got type %d", typ)
+		}
+
+		var msg message
+		if err := msgpack.Unmarshal(b, &msg); err != nil {
+			t.Fatalf("decode %x: %v", b, err)
+		}
+		return msg
+	}
+
+	send(map[string]any{"type": "connection_init"})
+	if msg := read(); msg.Type != "connection_ack" {
+		t.Fatalf("expected connection_ack, got %+v", msg)
+	}
+
+	send(map[string]any{
+		"id":   "1",
+		"type": "subscribe",
+		"payload": map[string]any{
+			"query":     "subscription($n: Int!) { count(upTo: $n) }",
+			"variables": map[string]any{"n": 2},
+		},
+	})
+
+	for i := 1; i <= 2; i++ {
+		msg := read()
+		if msg.ID != "1" || msg.Type != "next" || msg.Payload.Data.Count != i {
+			t.Fatalf("expected next with count %d, got %+v", i, msg)
+		}
+	}
+
+	if msg := read(); msg.Type != "complete" || msg.ID != "1" {
+		t.Fatalf("expected complete, got %+v", msg)
+	}
+}
+
+func TestMaxConnectionAge(t *testing.T) {
+	t.Parallel()
+
+	schema := graphql.MustParseSchema(schemaSDL, &resolver{})
+	srv := httptest.NewServer(graphqlws.NewHandlerFunc(schema, nil,
+		graphqlws.WithSubprotocol(msgpackcodec.Subprotocol, msgpackcodec.Codec{}),
+		graphqlws.WithMaxConnectionAge(10*time.Millisecond, 0),
+	))
+	t.Cleanup(srv.Close)
+
+	dialer := websocket.Dialer{Subprotocols: []string{msgpackcodec.Subprotocol}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	b, err := msgpack.Marshal(map[string]any{"type": "connection_init"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, b, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+
+		var msg struct {
+			Type    string            `msgpack:"type"`
+			Payload map[string]string `msgpack:"payload"`
+		}
+		if err := msgpack.Unmarshal(b, &msg); err != nil {
+			t.Fatalf("decode %x: %v", b, err)
+		}
+		if msg.Type != "ping" {
+			continue
+		}
+
+		if msg.Payload["reason"] != "max_connection_age" {
+			t.Fatalf("expected the ping to carry the reason, got %+v", msg.Payload)
+		}
+		return
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	t.Parallel()
+
+	codec := msgpackcodec.Codec{}
+
+	payload, err := codec.Marshal(map[string]any{"query": "{ hello }", "variables": map[string]any{"n": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := codec.EncodeMessage("1", "subscribe", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, typ, raw, err := codec.DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1" || typ != "subscribe" {
+		t.Fatalf("unexpected message %q %q", id, typ)
+	}
+
+	var got struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables"`
+	}
+	if err := codec.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Query != "{ hello }" || got.Variables["n"] != float64(1) {
+		t.Fatalf("unexpected payload %+v", got)
+	}
+}
@@ -0,0 +1,161 @@
+package graphqlws
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errorCodeTimeout is the extensions.code of the error ending an operation
+// that timed out, see TimeoutError.
+const errorCodeTimeout = "TIMEOUT"
+
+var (
+	errOperationMaxDuration = errors.New("operation exceeded its maximum duration")
+	errOperationIdle        = errors.New("operation timed out waiting for an event")
+)
+
+// Operation is a subscribe request about to be passed to the Subscriber.
+type Operation struct {
+	ID            string
+	OperationName string
+	Query         string
+	Variables     map[string]any
+	Extensions    map[string]any
+
+	// MaxDuration ends the operation once it has run for this long. It
+	// is preset from WithOperationMaxDuration. 0 means no limit.
+	MaxDuration time.Duration
+
+	// IdleTimeout ends the operation when the Subscriber sends no event
+	// for this long. It is preset from WithOperationIdleTimeout. 0 means
+	// no limit.
+	IdleTimeout time.Duration
+}
+
+// OnSubscribeFunc is called with the operation context before each
+// operation is subscribed, and may change the timeouts of op. A non-nil
+// error rejects the operation with an error message.
+type OnSubscribeFunc func(ctx context.Context, op *Operation) error
+
+// WithOnSubscribe calls f before each operation is subscribed.
+func WithOnSubscribe(f OnSubscribeFunc) Option {
+	return optionFunc(func(o *options) {
+		o.onSubscribe = f
+	})
+}
+
+// TimeoutAction selects how an operation ended by WithOperationMaxDuration
+// or WithOperationIdleTimeout is reported to the client.
+type TimeoutAction int
+
+const (
+	// TimeoutComplete sends complete, as if the subscription had ended.
+	TimeoutComplete TimeoutAction = iota
+
+	// TimeoutError sends an error with extensions.code TIMEOUT.
+	TimeoutError
+)
+
+// WithOperationMaxDuration ends operations that have run for d. The
+// operation context is canceled and the client is notified as set with
+// WithOperationTimeoutAction. There is no limit by default.
+func WithOperationMaxDuration(d time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.operationMaxDuration = max(d, 0)
+	})
+}
+
+// WithOperationIdleTimeout ends operations for which the Subscriber sends
+// no event within d, counted from the start of the operation and from each
+// event. The operation context is canceled and the client is notified as
+// set with WithOperationTimeoutAction. There is no limit by default.
+func WithOperationIdleTimeout(d time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.operationIdleTimeout = max(d, 0)
+	})
+}
+
+// WithOperationTimeoutAction sets how operations ended by
+// WithOperationMaxDuration and WithOperationIdleTimeout are reported. The
+// default is TimeoutComplete.
+func WithOperationTimeoutAction(action TimeoutAction) Option {
+	return optionFunc(func(o *options) {
+		o.timeoutAction = action
+	})
+}
+
+func transportOnSubscribe(f OnSubscribeFunc) transportOption {
+	return func(conn *connection) {
+		conn.onSubscribe = f
+	}
+}
+
+func transportOperationTimeouts(maxDuration, idle time.Duration, action TimeoutAction) transportOption {
+	return func(conn *connection) {
+		conn.opMaxDuration = maxDuration
+		conn.opIdleTimeout = idle
+		conn.timeoutAction = action
+	}
+}
+
+// operationTimer fires when an operation times out. The zero value never
+// fires.
+type operationTimer struct {
+	idle        time.Duration
+	maxDuration *time.Timer
+	idleTimer   *time.Timer
+}
+
+func newOperationTimer(op *Operation) *operationTimer {
+	t := &operationTimer{idle: op.IdleTimeout}
+	if op.MaxDuration > 0 {
+		t.maxDuration = time.NewTimer(op.MaxDuration)
+	}
+	if op.IdleTimeout > 0 {
+		t.idleTimer = time.NewTimer(op.IdleTimeout)
+	}
+	return t
+}
+
+// expired returns the channels the max duration and idle timeouts fire on.
+// A nil channel never fires.
+func (t *operationTimer) expired() (maxDuration, idle <-chan time.Time) {
+	if t.maxDuration != nil {
+		maxDuration = t.maxDuration.C
+	}
+	if t.idleTimer != nil {
+		idle = t.idleTimer.C
+	}
+	return maxDuration, idle
+}
+
+// event restarts the idle timeout.
+func (t *operationTimer) event() {
+	if t.idleTimer != nil {
+		t.idleTimer.Reset(t.idle)
+	}
+}
+
+func (t *operationTimer) stop() {
+	if t.maxDuration != nil {
+		t.maxDuration.Stop()
+	}
+	if t.idleTimer != nil {
+		t.idleTimer.Stop()
+	}
+}
+
+// timeout ends the operation id, which timed out with err, as set with
+// WithOperationTimeoutAction.
+func (conn *connection) timeout(id string, err error, send sendFunc, ops operationMap) {
+	if opCancel, ok := ops.get(id); ok {
+		opCancel()
+	}
+
+	if conn.timeoutAction == TimeoutError {
+		send(&operationMessage{ID: id, Type: typeError, Payload: conn.errPayloadCode(err, errorCodeTimeout)})
+		return
+	}
+	send(&operationMessage{ID: id, Type: typeComplete})
+}
@@ -0,0 +1,124 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOperationTimeouts(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		maxDuration time.Duration
+		idle        time.Duration
+		action      TimeoutAction
+		onSubscribe OnSubscribeFunc
+		events      int // events sent 10ms apart before the stream blocks
+		wantNext    int
+		want        string
+	}{
+		"max duration": {
+			maxDuration: 30 * time.Millisecond,
+			events:      100,
+			want:        `{"id":"1","type":"complete"}`,
+		},
+		"idle timeout error": {
+			idle:     50 * time.Millisecond,
+			action:   TimeoutError,
+			events:   3,
+			wantNext: 3,
+			want:     `{"id":"1","type":"error","payload":[{"message":"operation timed out waiting for an event","extensions":{"code":"TIMEOUT"}}]}`,
+		},
+		"max duration error": {
+			maxDuration: 30 * time.Millisecond,
+			idle:        time.Second,
+			action:      TimeoutError,
+			want:        `{"id":"1","type":"error","payload":[{"message":"operation exceeded its maximum duration","extensions":{"code":"TIMEOUT"}}]}`,
+		},
+		"overridden by hook": {
+			maxDuration: time.Hour,
+			onSubscribe: func(ctx context.Context, op *Operation) error {
+				if op.ID != "1" || op.Query != "subscription { a }" || op.MaxDuration != time.Hour {
+					return errors.New("unexpected operation")
+				}
+				op.MaxDuration = 0
+				op.IdleTimeout = 20 * time.Millisecond
+				return nil
+			},
+			want: `{"id":"1","type":"complete"}`,
+		},
+		"rejected by hook": {
+			onSubscribe: func(ctx context.Context, op *Operation) error {
+				return errors.New("forbidden")
+			},
+			want: `{"id":"1","type":"error","payload":[{"message":"forbidden"}]}`,
+		},
+	}
+
+	for name, tt := range testTable {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			canceled := make(chan struct{})
+			h := setupTest(t)
+			h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+				c := make(chan any)
+				go func() {
+					defer close(canceled)
+					for i := range tt.events {
+						select {
+						case c <- i:
+						case <-ctx.Done():
+							return
+						}
+						time.Sleep(10 * time.Millisecond)
+					}
+					<-ctx.Done()
+				}()
+				return c, nil
+			}
+
+			opts := []transportOption{transportOperationTimeouts(tt.maxDuration, tt.idle, tt.action)}
+			if tt.onSubscribe != nil {
+				opts = append(opts, transportOnSubscribe(tt.onSubscribe))
+			}
+			go connectTransport(context.Background(), h.conn, h.mockSvc, opts...)
+			defer close(h.conn.in)
+
+			h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+			h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`)
+			requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+			var next int
+			for {
+				msg := requireMessage(t, h.conn)
+				var m struct {
+					Type string `json:"type"`
+				}
+				_ = json.Unmarshal(msg, &m)
+				if m.Type == "next" {
+					next++
+					continue
+				}
+
+				requireEqualJSON(t, tt.want, msg, "")
+				break
+			}
+			if tt.wantNext > 0 && next != tt.wantNext {
+				t.Fatalf("want %d next messages, got %d", tt.wantNext, next)
+			}
+
+			if len(h.mockSvc.getCalls()) == 0 {
+				return
+			}
+			select {
+			case <-canceled:
+			case <-time.After(time.Second):
+				t.Fatal("expected the operation context to be canceled")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package graphqlws
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxSharedFrames bounds the number of complete next messages a PreEncoded
+// value keeps per codec. Frames are keyed by operation ID, so they are only
+// shared between clients that use the same ID for the operation, which is
+// common for clients that number their operations.
+const maxSharedFrames = 16
+
+// PreEncoded is a payload that is encoded once per codec, no matter how many
+// subscriptions it is sent to. Send the same *PreEncoded on the channels of
+// every subscription that should receive the event:
+//
+//	event := graphqlws.NewPreEncoded(response)
+//	for _, c := range subscribers {
+//		c <- event
+//	}
+//
+// Operations with the same ID on connections using the same codec also share
+// the complete next message, which is written to each connection as a
+// prepared frame, compressed at most once.
+//
+// When WithReplayBuffer is enabled, each stream stamps its own cursor into
+// the payload, so only the encoding of the value is shared.
+type PreEncoded struct {
+	value any
+
+	mu       sync.Mutex
+	payloads map[string]*encodedPayload // by codec name
+}
+
+type encodedPayload struct {
+	data   []byte
+	err    error
+	frames map[string]*sharedFrame // by operation ID
+}
+
+// NewPreEncoded returns a PreEncoded for v. v must not be modified after the
+// PreEncoded has been sent.
+func NewPreEncoded(v any) *PreEncoded {
+	return &PreEncoded{value: v}
+}
+
+// Value returns the value passed to NewPreEncoded.
+func (p *PreEncoded) Value() any {
+	return p.value
+}
+
+func (p *PreEncoded) encoded(codec Codec) *encodedPayload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.payloads[codec.Name()]
+	if !ok {
+		e = &encodedPayload{}
+		e.data, e.err = codec.Marshal(p.value)
+
+		if p.payloads == nil {
+			p.payloads = make(map[string]*encodedPayload)
+		}
+		p.payloads[codec.Name()] = e
+	}
+
+	return e
+}
+
+// payload returns the value encoded with codec.
+func (p *PreEncoded) payload(codec Codec) ([]byte, error) {
+	e := p.encoded(codec)
+	return e.data, e.err
+}
+
+// frame returns the next message for the operation id encoded with codec. It
+// returns nil once maxSharedFrames frames exist for other IDs.
+func (p *PreEncoded) frame(codec Codec, id string) (*sharedFrame, error) {
+	e := p.encoded(codec)
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if f, ok := e.frames[id]; ok {
+		return f, nil
+	}
+	if len(e.frames) >= maxSharedFrames {
+		return nil, nil
+	}
+
+	data, err := codec.EncodeMessage(id, string(typeNext), e.data)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &sharedFrame{typ: codec.MessageType(), data: data}
+	if e.frames == nil {
+		e.frames = make(map[string]*sharedFrame)
+	}
+	e.frames[id] = f
+
+	return f, nil
+}
+
+// sharedFrame is a complete encoded message written to many connections.
+type sharedFrame struct {
+	typ  MessageType
+	data []byte
+
+	once     sync.Once
+	prepared *websocket.PreparedMessage
+	err      error
+}
+
+// preparedMessage returns the frame as a gorilla PreparedMessage, which
+// caches the wire format, including its compressed form, across connections.
+func (f *sharedFrame) preparedMessage() (*websocket.PreparedMessage, error) {
+	f.once.Do(func() {
+		f.prepared, f.err = websocket.NewPreparedMessage(int(f.typ), f.data)
+	})
+	return f.prepared, f.err
+}
+
+// frameWriter is implemented by connections that write shared frames more
+// efficiently than as individual messages.
+type frameWriter interface {
+	writeFrame(f *sharedFrame) error
+}
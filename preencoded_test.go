@@ -0,0 +1,107 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPreEncoded(t *testing.T) {
+	t.Parallel()
+
+	t.Run("encodes once per codec", func(t *testing.T) {
+		t.Parallel()
+
+		var marshaled atomic.Int32
+		codec := JSONCodec{
+			MarshalFunc: func(v any) ([]byte, error) {
+				marshaled.Add(1)
+				return json.Marshal(v)
+			},
+		}
+
+		event := NewPreEncoded(map[string]any{"data": map[string]int{"n": 1}})
+
+		var conns []*mockConnection
+		for i := range 3 {
+			conn := newMockConnection()
+			conns = append(conns, conn)
+
+			svc := &fakeTransportService{
+				subscribeFn: func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+					c := make(chan any, 1)
+					c <- event
+					close(c)
+					return c, nil
+				},
+			}
+			go connectTransport(context.Background(), conn, svc, transportCodec(codec))
+
+			// Two connections use the same operation ID and share a frame.
+			id := fmt.Sprint(min(i, 1))
+			conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+			conn.in <- json.RawMessage(fmt.Sprintf(`{"id":%q,"type":"subscribe","payload":{"query":"subscription { n }"}}`, id))
+		}
+
+		for i, conn := range conns {
+			requireMessageType(t, requireMessage(t, conn), "connection_ack")
+			want := fmt.Sprintf(`{"id":"%d","type":"next","payload":{"data":{"n":1}}}`, min(i, 1))
+			requireEqualJSON(t, want, requireMessage(t, conn), "")
+			requireMessageType(t, requireMessage(t, conn), "complete")
+			close(conn.in)
+		}
+
+		if n := marshaled.Load(); n != 1 {
+			t.Fatalf("expected the event to be marshaled once, got %d", n)
+		}
+		if len(event.payloads["json"].frames) != 2 {
+			t.Fatalf("expected a frame per operation ID, got %d", len(event.payloads["json"].frames))
+		}
+	})
+
+	t.Run("bounds shared frames", func(t *testing.T) {
+		t.Parallel()
+
+		event := NewPreEncoded(json.RawMessage(`{"data":{}}`))
+		for i := range maxSharedFrames {
+			f, err := event.frame(JSONCodec{}, fmt.Sprint(i))
+			if err != nil || f == nil {
+				t.Fatalf("expected frame %d, got %v (%v)", i, f, err)
+			}
+		}
+
+		f, err := event.frame(JSONCodec{}, "overflow")
+		if err != nil || f != nil {
+			t.Fatalf("expected no frame beyond the limit, got %v (%v)", f, err)
+		}
+
+		first, _ := event.frame(JSONCodec{}, "0")
+		if again, _ := event.frame(JSONCodec{}, "0"); again != first {
+			t.Fatal("expected frames for the same ID to be shared")
+		}
+	})
+
+	t.Run("marshal error", func(t *testing.T) {
+		t.Parallel()
+
+		h := setupTest(t)
+		h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any, 1)
+			c <- NewPreEncoded(func() {})
+			return c, nil
+		}
+
+		go connectTransport(context.Background(), h.conn, h.mockSvc)
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+		h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`)
+
+		requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+		msg := requireMessage(t, h.conn)
+		requireMessageType(t, msg, "error")
+		requireErrorMessageContains(t, msg, "failed to marshal payload")
+		close(h.conn.in)
+	})
+}
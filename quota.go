@@ -0,0 +1,227 @@
+package graphqlws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errorCodeRateLimited is the extensions.code of the error ending an
+// operation that exceeded an output quota.
+const errorCodeRateLimited = "RATE_LIMITED"
+
+// quotaSlots is the number of slots a quota window is divided into. The
+// window slides by a slot at a time.
+const quotaSlots = 10
+
+// OutputQuota bounds the next messages sent within a sliding window.
+type OutputQuota struct {
+	// Window is the duration over which messages are counted. It must be
+	// positive.
+	Window time.Duration
+
+	// Bytes is the maximum size of the payloads sent within Window. 0
+	// means no limit.
+	Bytes int64
+
+	// Messages is the maximum number of messages sent within Window. 0
+	// means no limit.
+	Messages int64
+}
+
+// WithConnectionOutputQuota bounds the next messages sent on each
+// connection. An operation whose next message would exceed the quota is
+// ended with an error with extensions.code RATE_LIMITED and reported to
+// MetricsHooks.OnOutputQuotaExceeded. It panics if q.Window is not
+// positive.
+func WithConnectionOutputQuota(q OutputQuota) Option {
+	q.mustValidate()
+
+	return optionFunc(func(o *options) {
+		o.connectionQuota = &q
+	})
+}
+
+// WithKeyOutputQuota bounds the next messages sent to all connections with
+// the same key, as returned by key for the connection context, for example
+// the user. Operations exceeding the quota are ended as with
+// WithConnectionOutputQuota. Connections for which key returns "" are only
+// subject to WithConnectionOutputQuota. A nil key shares a single quota,
+// with the key "*", between all connections. It panics if q.Window is not
+// positive.
+func WithKeyOutputQuota(q OutputQuota, key func(context.Context) string) Option {
+	q.mustValidate()
+	if key == nil {
+		key = func(context.Context) string { return "*" }
+	}
+
+	quota := newOutputQuota(q, key)
+	return optionFunc(func(o *options) {
+		o.keyQuota = quota
+	})
+}
+
+// mustValidate panics if q has no window, which would count every message
+// in a window of its own and never exceed the quota.
+func (q OutputQuota) mustValidate() {
+	if q.Window <= 0 {
+		panic(fmt.Sprintf("graphqlws: output quota window must be positive, got %v", q.Window))
+	}
+}
+
+// transportOutputQuotas counts the next messages of the connection towards
+// a quota of its own, created from perConn, and the shared quota byKey.
+// Either may be nil.
+func transportOutputQuotas(perConn *OutputQuota, byKey *outputQuota, exceeded func(key string)) transportOption {
+	return func(conn *connection) {
+		if perConn != nil {
+			conn.connQuota = newOutputQuota(*perConn, nil)
+		}
+		conn.keyQuota = byKey
+		conn.onQuotaExceeded = exceeded
+	}
+}
+
+// outputQuota tracks the messages sent per key.
+type outputQuota struct {
+	quota OutputQuota
+	key   func(context.Context) string
+
+	mu        sync.Mutex
+	windows   map[string]*quotaWindow
+	lastPrune time.Time
+}
+
+// quotaWindow counts messages in slots of a sliding window.
+type quotaWindow struct {
+	slots [quotaSlots]struct {
+		index    int64 // slot number since the epoch
+		bytes    int64
+		messages int64
+	}
+}
+
+func newOutputQuota(q OutputQuota, key func(context.Context) string) *outputQuota {
+	// Every slot lasts at least a nanosecond.
+	q.Window = max(q.Window, quotaSlots)
+
+	return &outputQuota{
+		quota:   q,
+		key:     key,
+		windows: make(map[string]*quotaWindow),
+	}
+}
+
+// fits reports whether a message of size bytes stays within the quota of
+// key. q.mu must be held.
+func (q *outputQuota) fits(key string, size int, now time.Time) bool {
+	slot := q.slot(now)
+	if now.Sub(q.lastPrune) >= q.quota.Window {
+		q.prune(slot)
+		q.lastPrune = now
+	}
+
+	var bytes, messages int64
+	if w, ok := q.windows[key]; ok {
+		for _, s := range w.slots {
+			if slot-s.index < quotaSlots {
+				bytes += s.bytes
+				messages += s.messages
+			}
+		}
+	}
+
+	return !(q.quota.Bytes > 0 && bytes+int64(size) > q.quota.Bytes ||
+		q.quota.Messages > 0 && messages+1 > q.quota.Messages)
+}
+
+// add counts a message of size bytes towards the quota of key. q.mu must be
+// held.
+func (q *outputQuota) add(key string, size int, now time.Time) {
+	w, ok := q.windows[key]
+	if !ok {
+		w = &quotaWindow{}
+		q.windows[key] = w
+	}
+
+	slot := q.slot(now)
+	s := &w.slots[slot%quotaSlots]
+	if s.index != slot {
+		s.index, s.bytes, s.messages = slot, 0, 0
+	}
+	s.bytes += int64(size)
+	s.messages++
+}
+
+func (q *outputQuota) slot(now time.Time) int64 {
+	return now.UnixNano() / int64(q.quota.Window/quotaSlots)
+}
+
+// prune drops the windows of keys without messages in the current window.
+func (q *outputQuota) prune(slot int64) {
+	for key, w := range q.windows {
+		var latest int64
+		for _, s := range w.slots {
+			latest = max(latest, s.index)
+		}
+		if slot-latest >= quotaSlots {
+			delete(q.windows, key)
+		}
+	}
+}
+
+// takeOutput counts msg towards the output quotas of the connection and
+// reports whether it may be sent.
+func (conn *connection) takeOutput(msg *operationMessage, quotaKey string) bool {
+	byKey := conn.keyQuota
+	if quotaKey == "" {
+		byKey = nil
+	}
+	if conn.connQuota == nil && byKey == nil {
+		return true
+	}
+
+	exceeded, ok := takeQuotas(conn.connQuota, byKey, quotaKey, len(msg.Payload), time.Now())
+	if !ok && conn.onQuotaExceeded != nil {
+		conn.onQuotaExceeded(exceeded)
+	}
+
+	return ok
+}
+
+// takeQuotas counts a message of size bytes towards the quota of a
+// connection and the quota of key, either of which may be nil. The message
+// is only counted once both quotas allow it, so that a message rejected by
+// one quota does not use up the other. exceeded is key if its quota
+// rejected the message.
+func takeQuotas(byConn, byKey *outputQuota, key string, size int, now time.Time) (exceeded string, ok bool) {
+	// The connection quota is always locked first.
+	if byConn != nil {
+		byConn.mu.Lock()
+		defer byConn.mu.Unlock()
+	}
+	if byKey != nil {
+		byKey.mu.Lock()
+		defer byKey.mu.Unlock()
+	}
+
+	if byConn != nil && !byConn.fits("", size, now) {
+		return "", false
+	}
+	if byKey != nil && !byKey.fits(key, size, now) {
+		return key, false
+	}
+
+	if byConn != nil {
+		byConn.add("", size, now)
+	}
+	if byKey != nil {
+		byKey.add(key, size, now)
+	}
+	return "", true
+}
+
+// errOutputQuota ends operations that exceeded an output quota.
+var errOutputQuota = errors.New("output quota exceeded")
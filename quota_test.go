@@ -0,0 +1,200 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// takeQuota counts a message of size bytes towards the quota of key.
+func takeQuota(q *outputQuota, key string, size int, now time.Time) bool {
+	_, ok := takeQuotas(nil, q, key, size, now)
+	return ok
+}
+
+func TestOutputQuotaWindow(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000, 0)
+
+	t.Run("messages", func(t *testing.T) {
+		t.Parallel()
+
+		q := newOutputQuota(OutputQuota{Window: time.Second, Messages: 2}, nil)
+		if !takeQuota(q, "a", 1, now) || !takeQuota(q, "a", 1, now.Add(500*time.Millisecond)) {
+			t.Fatal("expected messages within the quota to be allowed")
+		}
+		if takeQuota(q, "a", 1, now.Add(900*time.Millisecond)) {
+			t.Fatal("expected message over the quota to be rejected")
+		}
+		if !takeQuota(q, "b", 1, now) {
+			t.Fatal("expected keys to have separate quotas")
+		}
+
+		// The first message leaves the window after a second, the
+		// second one half a second later.
+		if !takeQuota(q, "a", 1, now.Add(time.Second)) {
+			t.Fatal("expected message after the window slid to be allowed")
+		}
+		if takeQuota(q, "a", 1, now.Add(1400*time.Millisecond)) {
+			t.Fatal("expected message over the quota to be rejected")
+		}
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		t.Parallel()
+
+		q := newOutputQuota(OutputQuota{Window: time.Minute, Bytes: 10}, nil)
+		if !takeQuota(q, "", 6, now) {
+			t.Fatal("expected message within the quota to be allowed")
+		}
+		if takeQuota(q, "", 5, now) {
+			t.Fatal("expected message over the quota to be rejected")
+		}
+		if !takeQuota(q, "", 4, now) {
+			t.Fatal("expected rejected messages not to be counted")
+		}
+	})
+
+	t.Run("rejected messages use up neither quota", func(t *testing.T) {
+		t.Parallel()
+
+		byConn := newOutputQuota(OutputQuota{Window: time.Minute, Messages: 2}, nil)
+		byKey := newOutputQuota(OutputQuota{Window: time.Minute, Bytes: 10}, nil)
+		if _, ok := takeQuotas(byConn, byKey, "a", 6, now); !ok {
+			t.Fatal("expected message within both quotas to be allowed")
+		}
+		if exceeded, ok := takeQuotas(byConn, byKey, "a", 5, now); ok || exceeded != "a" {
+			t.Fatalf("expected the key quota to reject the message, got %q, %t", exceeded, ok)
+		}
+		if _, ok := takeQuotas(byConn, byKey, "a", 4, now); !ok {
+			t.Fatal("expected the rejected message not to count towards the connection quota")
+		}
+		if exceeded, ok := takeQuotas(byConn, byKey, "b", 1, now); ok || exceeded != "" {
+			t.Fatalf("expected the connection quota to reject the message, got %q, %t", exceeded, ok)
+		}
+		if !takeQuota(byKey, "b", 10, now) {
+			t.Fatal("expected the rejected message not to count towards the key quota")
+		}
+	})
+
+	t.Run("requires a window", func(t *testing.T) {
+		t.Parallel()
+
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected a quota without window to panic")
+			}
+		}()
+		WithConnectionOutputQuota(OutputQuota{Messages: 1})
+	})
+
+	t.Run("prunes idle keys", func(t *testing.T) {
+		t.Parallel()
+
+		q := newOutputQuota(OutputQuota{Window: time.Second, Messages: 1}, nil)
+		takeQuota(q, "a", 1, now)
+		takeQuota(q, "b", 1, now.Add(2*time.Second))
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if _, ok := q.windows["a"]; ok || len(q.windows) != 1 {
+			t.Fatalf("expected idle key to be pruned, got %d windows", len(q.windows))
+		}
+	})
+}
+
+func TestOutputQuota(t *testing.T) {
+	t.Parallel()
+
+	type userKey struct{}
+
+	var (
+		mu       sync.Mutex
+		exceeded []string
+	)
+	onExceeded := func(key string) {
+		mu.Lock()
+		exceeded = append(exceeded, key)
+		mu.Unlock()
+	}
+
+	keyQuota := newOutputQuota(OutputQuota{Window: time.Hour, Messages: 3}, func(ctx context.Context) string {
+		user, _ := ctx.Value(userKey{}).(string)
+		return user
+	})
+
+	// run subscribes on a new connection of user to a subscription with
+	// five events and returns the number of events received before the
+	// operation ended.
+	run := func(t *testing.T, user string, perConn *OutputQuota) (int, json.RawMessage) {
+		t.Helper()
+
+		h := setupTest(t)
+		h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any, 5)
+			for i := range 5 {
+				c <- map[string]int{"n": i}
+			}
+			close(c)
+			return c, nil
+		}
+
+		ctx := context.WithValue(context.Background(), userKey{}, user)
+		go connectTransport(ctx, h.conn, h.mockSvc, transportOutputQuotas(perConn, keyQuota, onExceeded))
+		defer close(h.conn.in)
+
+		h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+		h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`)
+		requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+		var n int
+		for {
+			msg := requireMessage(t, h.conn)
+			var m struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal(msg, &m)
+			if m.Type != "next" {
+				return n, msg
+			}
+			n++
+		}
+	}
+
+	n, last := run(t, "a", &OutputQuota{Window: time.Hour, Messages: 2})
+	if n != 2 {
+		t.Fatalf("expected the connection quota to allow 2 messages, got %d", n)
+	}
+	requireEqualJSON(t, `{"id":"1","type":"error","payload":[{"message":"output quota exceeded","extensions":{"code":"RATE_LIMITED"}}]}`, last, "")
+
+	// User a has one message of their quota left.
+	if n, last = run(t, "a", nil); n != 1 {
+		t.Fatalf("expected the key quota to allow 1 message, got %d", n)
+	}
+	requireMessageType(t, last, "error")
+
+	if n, last = run(t, "b", nil); n != 3 {
+		t.Fatalf("expected another key to have its own quota, got %d messages", n)
+	}
+	requireMessageType(t, last, "error")
+
+	if n, last = run(t, "", nil); n != 5 {
+		t.Fatalf("expected connections without key to be unlimited, got %d messages", n)
+	}
+	requireMessageType(t, last, "complete")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"", "a", "b"}
+	if len(exceeded) != len(want) {
+		t.Fatalf("want exceeded %q, got %q", want, exceeded)
+	}
+	for i := range want {
+		if exceeded[i] != want[i] {
+			t.Fatalf("want exceeded %q, got %q", want, exceeded)
+		}
+	}
+}
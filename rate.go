@@ -0,0 +1,220 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const extensionRate = "rate"
+
+// RateMode selects how a RatePolicy limits the events of an operation.
+type RateMode string
+
+const (
+	// RateThrottle sends at most Limit events per Interval. Events beyond
+	// the limit are conflated: only the latest is kept and sent as soon as
+	// the limit allows.
+	RateThrottle RateMode = "throttle"
+
+	// RateDebounce sends an event once no newer event has arrived for
+	// Interval.
+	RateDebounce RateMode = "debounce"
+
+	// RateLatest conflates events while the connection is busy writing, so
+	// that a client that falls behind is sent the latest event instead of
+	// every event it missed.
+	RateLatest RateMode = "latest"
+)
+
+// RatePolicy limits the rate at which the events of an operation are sent
+// to the client. Events that are conflated are dropped; the last event of a
+// subscription is always sent before it completes.
+type RatePolicy struct {
+	Mode RateMode
+
+	// Limit is the number of events per Interval for RateThrottle. Values
+	// below 1 are treated as 1.
+	Limit int
+
+	// Interval is the throttle window for RateThrottle and the quiet period
+	// for RateDebounce. It is unused for RateLatest.
+	Interval time.Duration
+}
+
+func (p RatePolicy) validate() error {
+	switch p.Mode {
+	case RateThrottle, RateDebounce:
+		if p.Interval <= 0 {
+			return fmt.Errorf("rate policy %s requires a positive interval", p.Mode)
+		}
+	case RateLatest:
+	default:
+		return fmt.Errorf("unknown rate policy mode %q", p.Mode)
+	}
+
+	return nil
+}
+
+// WithRatePolicy limits the events of operations named operationName with
+// policy, overriding any policy the client requests.
+//
+// Without a server-side policy, clients may request one for an operation in
+// the extensions of its subscribe payload, with the interval in
+// milliseconds:
+//
+//	{"extensions": {"rate": {"mode": "throttle", "limit": 4, "interval": 1000}}}
+//
+// WithRatePolicy panics if policy is invalid, for example a RateThrottle
+// without an interval; a client requesting an invalid policy receives an
+// error for the operation.
+func WithRatePolicy(operationName string, policy RatePolicy) Option {
+	if err := policy.validate(); err != nil {
+		panic("graphqlws: " + err.Error())
+	}
+
+	return optionFunc(func(o *options) {
+		if o.ratePolicies == nil {
+			o.ratePolicies = make(map[string]RatePolicy)
+		}
+		o.ratePolicies[operationName] = policy
+	})
+}
+
+// transportRatePolicies limits the events of operations by operation name.
+func transportRatePolicies(policies map[string]RatePolicy) transportOption {
+	return func(conn *connection) {
+		conn.ratePolicies = policies
+	}
+}
+
+// ratePolicy returns the policy of the server for the operation, or the one
+// requested by the client.
+func (conn *connection) ratePolicy(payload subscribeMessagePayload) (RatePolicy, bool, error) {
+	if p, ok := conn.ratePolicies[payload.OperationName]; ok {
+		return p, true, nil
+	}
+
+	raw, ok := payload.Extensions[extensionRate]
+	if !ok {
+		return RatePolicy{}, false, nil
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return RatePolicy{}, false, err
+	}
+
+	var requested struct {
+		Mode     RateMode `json:"mode"`
+		Limit    int      `json:"limit"`
+		Interval float64  `json:"interval"`
+	}
+	if err := json.Unmarshal(b, &requested); err != nil {
+		return RatePolicy{}, false, errors.New("invalid rate extension")
+	}
+
+	p := RatePolicy{
+		Mode:     requested.Mode,
+		Limit:    requested.Limit,
+		Interval: time.Duration(requested.Interval * float64(time.Millisecond)),
+	}
+	if err := p.validate(); err != nil {
+		return RatePolicy{}, false, err
+	}
+
+	return p, true, nil
+}
+
+// apply returns a channel that receives the events of in as limited by the
+// policy. It is closed after in is closed and the last event was received,
+// or when ctx is done.
+func (p RatePolicy) apply(ctx context.Context, in <-chan any) <-chan any {
+	out := make(chan any)
+
+	go func() {
+		defer close(out)
+
+		timer := time.NewTimer(time.Hour)
+		timer.Stop()
+
+		var (
+			pending    any
+			hasPending bool
+			received   time.Time   // when pending arrived
+			sent       []time.Time // the last Limit sends, oldest first
+		)
+
+		for {
+			var (
+				offer chan any
+				wait  <-chan time.Time
+			)
+			if hasPending {
+				if next := p.next(received, sent, in == nil); next.IsZero() || !time.Now().Before(next) {
+					offer = out
+				} else {
+					timer.Reset(time.Until(next))
+					wait = timer.C
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case v, ok := <-in:
+				if !ok {
+					if !hasPending {
+						return
+					}
+					in = nil
+					continue
+				}
+				pending, hasPending, received = v, true, time.Now()
+
+			case offer <- pending:
+				if in == nil {
+					return
+				}
+				pending, hasPending = nil, false
+				if p.Mode == RateThrottle {
+					sent = append(sent, time.Now())
+					if len(sent) > max(p.Limit, 1) {
+						sent = sent[1:]
+					}
+				}
+
+			case <-wait:
+			}
+
+			if wait != nil {
+				timer.Stop()
+			}
+		}
+	}()
+
+	return out
+}
+
+// next returns the earliest time at which the pending event may be sent, or
+// the zero time if it may be sent right away.
+func (p RatePolicy) next(received time.Time, sent []time.Time, closed bool) time.Time {
+	switch p.Mode {
+	case RateThrottle:
+		if len(sent) < max(p.Limit, 1) {
+			return time.Time{}
+		}
+		return sent[0].Add(p.Interval)
+
+	case RateDebounce:
+		if closed {
+			return time.Time{}
+		}
+		return received.Add(p.Interval)
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,214 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, c <-chan any) ([]int, []time.Time) {
+	t.Helper()
+
+	var (
+		got []int
+		at  []time.Time
+	)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case v, ok := <-c:
+			if !ok {
+				return got, at
+			}
+			got = append(got, v.(int))
+			at = append(at, time.Now())
+		case <-timeout:
+			t.Fatalf("timed out, received %v", got)
+		}
+	}
+}
+
+func TestRatePolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("throttle", func(t *testing.T) {
+		t.Parallel()
+
+		p := RatePolicy{Mode: RateThrottle, Limit: 2, Interval: 100 * time.Millisecond}
+		in := make(chan any)
+		go func() {
+			defer close(in)
+			for i := range 10 {
+				in <- i
+				time.Sleep(20 * time.Millisecond)
+			}
+		}()
+
+		got, at := collect(t, p.apply(context.Background(), in))
+		if len(got) == 0 || got[len(got)-1] != 9 {
+			t.Fatalf("expected the last event to be sent, got %v", got)
+		}
+		if len(got) >= 10 {
+			t.Fatalf("expected events to be dropped, got %v", got)
+		}
+		for i := range got[1:] {
+			if got[i+1] <= got[i] {
+				t.Fatalf("events out of order: %v", got)
+			}
+		}
+		for i := 2; i < len(at); i++ {
+			if d := at[i].Sub(at[i-2]); d < p.Interval-10*time.Millisecond {
+				t.Fatalf("3 events within %v: %v", d, got)
+			}
+		}
+	})
+
+	t.Run("debounce", func(t *testing.T) {
+		t.Parallel()
+
+		p := RatePolicy{Mode: RateDebounce, Interval: 50 * time.Millisecond}
+		in := make(chan any)
+		go func() {
+			defer close(in)
+			for i := range 10 {
+				if i == 5 {
+					time.Sleep(150 * time.Millisecond)
+				}
+				in <- i
+			}
+		}()
+
+		got, _ := collect(t, p.apply(context.Background(), in))
+		if len(got) != 2 || got[0] != 4 || got[1] != 9 {
+			t.Fatalf("expected [4 9], got %v", got)
+		}
+	})
+
+	t.Run("latest", func(t *testing.T) {
+		t.Parallel()
+
+		p := RatePolicy{Mode: RateLatest}
+		in := make(chan any)
+		out := p.apply(context.Background(), in)
+
+		// Nothing reads while the events arrive, as when the connection
+		// is busy writing.
+		for i := range 100 {
+			in <- i
+		}
+		close(in)
+
+		got, _ := collect(t, out)
+		if len(got) != 1 || got[0] != 99 {
+			t.Fatalf("expected [99], got %v", got)
+		}
+	})
+
+	t.Run("invalid server policy", func(t *testing.T) {
+		t.Parallel()
+
+		for _, p := range []RatePolicy{
+			{Mode: RateThrottle, Limit: 1},
+			{Mode: "burst", Interval: time.Second},
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatalf("expected policy %+v to panic", p)
+					}
+				}()
+				WithRatePolicy("Ticks", p)
+			}()
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		out := RatePolicy{Mode: RateDebounce, Interval: time.Hour}.apply(ctx, make(chan any))
+		cancel()
+
+		if got, _ := collect(t, out); len(got) != 0 {
+			t.Fatalf("expected no events, got %v", got)
+		}
+	})
+}
+
+func TestRatePolicySelection(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		policies  map[string]RatePolicy
+		payload   string
+		want      []string
+		wantError string
+	}{
+		"no policy": {
+			payload: `{"query":"subscription { n }"}`,
+			want:    []string{`{"n":0}`, `{"n":1}`, `{"n":2}`},
+		},
+		"client policy": {
+			payload: `{"query":"subscription { n }","extensions":{"rate":{"mode":"debounce","interval":1000}}}`,
+			want:    []string{`{"n":2}`},
+		},
+		"server policy overrides client": {
+			policies: map[string]RatePolicy{"Ticks": {Mode: RateDebounce, Interval: time.Second}},
+			payload:  `{"query":"subscription Ticks { n }","operationName":"Ticks","extensions":{"rate":{"mode":"latest"}}}`,
+			want:     []string{`{"n":2}`},
+		},
+		"server policy for another operation": {
+			policies: map[string]RatePolicy{"Other": {Mode: RateDebounce, Interval: time.Second}},
+			payload:  `{"query":"subscription Ticks { n }","operationName":"Ticks"}`,
+			want:     []string{`{"n":0}`, `{"n":1}`, `{"n":2}`},
+		},
+		"invalid client policy": {
+			payload:   `{"query":"subscription { n }","extensions":{"rate":{"mode":"throttle"}}}`,
+			wantError: "requires a positive interval",
+		},
+		"unknown client mode": {
+			payload:   `{"query":"subscription { n }","extensions":{"rate":{"mode":"sometimes","interval":10}}}`,
+			wantError: "unknown rate policy mode",
+		},
+	}
+
+	for name, tt := range testTable {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := setupTest(t)
+			h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+				c := make(chan any, 3)
+				for i := range 3 {
+					c <- map[string]int{"n": i}
+				}
+				close(c)
+				return c, nil
+			}
+
+			go connectTransport(context.Background(), h.conn, h.mockSvc, transportRatePolicies(tt.policies))
+
+			h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+			h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":` + tt.payload + `}`)
+
+			requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+			if tt.wantError != "" {
+				msg := requireMessage(t, h.conn)
+				requireMessageType(t, msg, "error")
+				requireErrorMessageContains(t, msg, tt.wantError)
+				if calls := h.mockSvc.getCalls(); len(calls) != 0 {
+					t.Fatalf("expected no subscription, got %d", len(calls))
+				}
+				close(h.conn.in)
+				return
+			}
+
+			for _, want := range tt.want {
+				requireEqualJSON(t, `{"id":"1","type":"next","payload":`+want+`}`, requireMessage(t, h.conn), "")
+			}
+			requireMessageType(t, requireMessage(t, h.conn), "complete")
+			close(h.conn.in)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package graphqlws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RateLimitAction selects what happens to a client message that exceeds the
+// limits set with WithMessageRateLimit or WithSubscribeRateLimit.
+type RateLimitAction int
+
+const (
+	// RateLimitClose closes the connection with code 4429.
+	RateLimitClose RateLimitAction = iota
+
+	// RateLimitDrop ignores the message.
+	RateLimitDrop
+
+	// RateLimitError ignores the message and sends an error for its
+	// operation, which ends the operation if it is running. Messages
+	// without an operation ID, such as ping, are dropped, and a subscribe
+	// reusing the ID of a running operation closes the connection with
+	// code 4409 as it would within the limits.
+	RateLimitError
+)
+
+type rateLimit struct {
+	perSecond float64
+	burst     int
+}
+
+// WithMessageRateLimit limits the messages a client sends after
+// connection_init to perSecond on average, with bursts of up to burst
+// messages. Messages over the limit are handled as set with
+// WithRateLimitAction. There is no limit by default.
+func WithMessageRateLimit(perSecond float64, burst int) Option {
+	return optionFunc(func(o *options) {
+		o.messageRateLimit = &rateLimit{perSecond: perSecond, burst: max(burst, 1)}
+	})
+}
+
+// WithSubscribeRateLimit limits the subscribe messages a client sends to
+// perSecond on average, with bursts of up to burst messages. Subscribe
+// messages also count towards WithMessageRateLimit. Messages over the limit
+// are handled as set with WithRateLimitAction. There is no limit by default.
+func WithSubscribeRateLimit(perSecond float64, burst int) Option {
+	return optionFunc(func(o *options) {
+		o.subscribeRateLimit = &rateLimit{perSecond: perSecond, burst: max(burst, 1)}
+	})
+}
+
+// WithRateLimitAction sets what happens to messages over the limits set with
+// WithMessageRateLimit and WithSubscribeRateLimit. The default is
+// RateLimitClose.
+func WithRateLimitAction(action RateLimitAction) Option {
+	return optionFunc(func(o *options) {
+		o.rateLimitAction = action
+	})
+}
+
+func transportMessageRateLimit(l rateLimit) transportOption {
+	return func(conn *connection) {
+		conn.messageBucket = newTokenBucket(l)
+	}
+}
+
+func transportSubscribeRateLimit(l rateLimit) transportOption {
+	return func(conn *connection) {
+		conn.subscribeBucket = newTokenBucket(l)
+	}
+}
+
+func transportRateLimitAction(action RateLimitAction) transportOption {
+	return func(conn *connection) {
+		conn.rateLimitAction = action
+	}
+}
+
+// allowMessage reports whether msg is within the rate limits of the
+// connection. Otherwise it applies the rate limit action and reports
+// whether the connection remains open.
+func (conn *connection) allowMessage(msg *operationMessage, send sendFunc, ops operationMap) (allowed bool, open bool) {
+	now := time.Now()
+
+	// A token is only taken once both buckets have one, so that a message
+	// rejected by one limit does not count towards the other.
+	subscribe := msg.Type == typeSubscribe
+	if conn.messageBucket.available(now) && (!subscribe || conn.subscribeBucket.available(now)) {
+		conn.messageBucket.take()
+		if subscribe {
+			conn.subscribeBucket.take()
+		}
+		return true, true
+	}
+
+	if conn.rateLimitAction == RateLimitClose {
+		conn.closeWithCode(closeCodeTooManyRequests, "Rate limit exceeded")
+		return false, false
+	}
+
+	if subscribe {
+		// Reusing the ID of a running operation is a protocol error,
+		// whether or not the message is within the limits.
+		if _, exists := ops.get(msg.ID); exists {
+			conn.closeWithCode(closeCodeSubscriberAlreadyExists, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+			return false, false
+		}
+	}
+
+	if conn.rateLimitAction == RateLimitError && msg.ID != "" {
+		// A rejected subscribe never started an operation, while any other
+		// message of a running operation ends it.
+		if opCancel, ok := ops.get(msg.ID); ok && !subscribe {
+			opCancel()
+			ops.delete(msg.ID)
+		}
+		send(&operationMessage{ID: msg.ID, Type: typeError, Payload: conn.errPayload(errors.New("rate limit exceeded"))})
+	}
+
+	return false, true
+}
+
+// tokenBucket is a token bucket rate limiter. It is used by a single
+// goroutine. A nil *tokenBucket allows everything.
+type tokenBucket struct {
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+func newTokenBucket(l rateLimit) *tokenBucket {
+	return &tokenBucket{
+		perSecond: max(l.perSecond, 0),
+		burst:     float64(l.burst),
+		tokens:    float64(l.burst),
+	}
+}
+
+// available refills the bucket up to now and reports whether a token is
+// available.
+func (b *tokenBucket) available(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.perSecond)
+	}
+	b.last = now
+
+	return b.tokens >= 1
+}
+
+// take takes the token that available reported.
+func (b *tokenBucket) take() {
+	if b != nil {
+		b.tokens--
+	}
+}
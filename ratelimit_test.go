@@ -0,0 +1,232 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	b := newTokenBucket(rateLimit{perSecond: 2, burst: 3})
+	now := time.Now()
+
+	for i := range 3 {
+		if !b.available(now) {
+			t.Fatalf("expected burst message %d to be allowed", i)
+		}
+		b.take()
+	}
+	if b.available(now) {
+		t.Fatal("expected message beyond the burst to be limited")
+	}
+
+	// Two tokens per second refill one token in 500ms.
+	if b.available(now.Add(400 * time.Millisecond)) {
+		t.Fatal("expected message before the refill to be limited")
+	}
+	if !b.available(now.Add(500 * time.Millisecond)) {
+		t.Fatal("expected message after the refill to be allowed")
+	}
+
+	// Only take uses up the token.
+	if !b.available(now.Add(500 * time.Millisecond)) {
+		t.Fatal("expected the token to remain until it is taken")
+	}
+	b.take()
+
+	// Refills are capped at the burst size.
+	later := now.Add(time.Hour)
+	for i := range 3 {
+		if !b.available(later) {
+			t.Fatalf("expected refilled message %d to be allowed", i)
+		}
+		b.take()
+	}
+	if b.available(later) {
+		t.Fatal("expected refill to be capped at the burst size")
+	}
+
+	var unlimited *tokenBucket
+	if !unlimited.available(now) {
+		t.Fatal("expected a nil bucket to allow everything")
+	}
+	unlimited.take()
+}
+
+func TestInboundRateLimit(t *testing.T) {
+	t.Parallel()
+
+	// The limits refill a token every ~17 minutes, so only the burst is
+	// available during a test.
+	const slow = 0.001
+
+	testTable := map[string]struct {
+		opts           []transportOption
+		messages       []string
+		want           []string // message types, or "error:<id>"
+		wantCloseCode  int
+		subscribeCalls int
+		canceled       bool // the operation was canceled
+	}{
+		"close": {
+			opts: []transportOption{transportMessageRateLimit(rateLimit{perSecond: slow, burst: 2})},
+			messages: []string{
+				`{"type":"ping"}`,
+				`{"type":"ping"}`,
+				`{"type":"ping"}`,
+			},
+			want:          []string{"pong", "pong"},
+			wantCloseCode: closeCodeTooManyRequests,
+		},
+		"drop": {
+			opts: []transportOption{
+				transportSubscribeRateLimit(rateLimit{perSecond: slow, burst: 1}),
+				transportRateLimitAction(RateLimitDrop),
+			},
+			messages: []string{
+				`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+				`{"id":"2","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+				`{"type":"ping"}`,
+			},
+			want:           []string{"pong"},
+			subscribeCalls: 1,
+		},
+		"error": {
+			opts: []transportOption{
+				transportSubscribeRateLimit(rateLimit{perSecond: slow, burst: 1}),
+				transportRateLimitAction(RateLimitError),
+			},
+			messages: []string{
+				`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+				`{"id":"2","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+				`{"type":"ping"}`,
+			},
+			want:           []string{"error:2", "pong"},
+			subscribeCalls: 1,
+		},
+		"rejected subscribe does not count towards the message limit": {
+			opts: []transportOption{
+				transportMessageRateLimit(rateLimit{perSecond: slow, burst: 2}),
+				transportSubscribeRateLimit(rateLimit{perSecond: slow, burst: 1}),
+				transportRateLimitAction(RateLimitDrop),
+			},
+			messages: []string{
+				`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+				`{"id":"2","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+				`{"type":"ping"}`,
+			},
+			want:           []string{"pong"},
+			subscribeCalls: 1,
+		},
+		"error closes on a duplicate subscribe": {
+			opts: []transportOption{
+				transportSubscribeRateLimit(rateLimit{perSecond: slow, burst: 1}),
+				transportRateLimitAction(RateLimitError),
+			},
+			messages: []string{
+				`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+				`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+			},
+			wantCloseCode:  closeCodeSubscriberAlreadyExists,
+			subscribeCalls: 1,
+		},
+		"error ends running operation": {
+			opts: []transportOption{
+				transportMessageRateLimit(rateLimit{perSecond: slow, burst: 2}),
+				transportRateLimitAction(RateLimitError),
+			},
+			messages: []string{
+				`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`,
+				`{"type":"ping"}`,
+				`{"id":"1","type":"complete"}`,
+			},
+			want:           []string{"pong", "error:1"},
+			subscribeCalls: 1,
+			canceled:       true,
+		},
+		"error without operation ID": {
+			opts: []transportOption{
+				transportMessageRateLimit(rateLimit{perSecond: slow, burst: 1}),
+				transportRateLimitAction(RateLimitError),
+			},
+			messages: []string{
+				`{"type":"ping"}`,
+				`{"type":"ping"}`,
+			},
+			want: []string{"pong"},
+		},
+	}
+
+	for name, tt := range testTable {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := setupTest(t)
+			opCtx := make(chan context.Context, 1)
+			h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+				opCtx <- ctx
+				return make(chan any), nil
+			}
+
+			go connectTransport(context.Background(), h.conn, h.mockSvc, tt.opts...)
+
+			h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+			requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+			for _, msg := range tt.messages {
+				h.conn.in <- json.RawMessage(msg)
+			}
+			h.mockSvc.waitForCalls(tt.subscribeCalls)
+
+			for _, want := range tt.want {
+				msg := requireMessage(t, h.conn)
+				if id, ok := strings.CutPrefix(want, "error:"); ok {
+					requireMessageType(t, msg, "error")
+					requireErrorMessageContains(t, msg, "rate limit exceeded")
+					requireEqualJSON(t, `"`+id+`"`, requireField(t, msg, "id"), "")
+					continue
+				}
+				requireMessageType(t, msg, want)
+			}
+
+			if tt.wantCloseCode != 0 {
+				requireClosed(t, h.conn)
+				h.conn.mtx.Lock()
+				code := h.conn.closeCode
+				h.conn.mtx.Unlock()
+				if code != tt.wantCloseCode {
+					t.Fatalf("want close code %d, got %d", tt.wantCloseCode, code)
+				}
+				return
+			}
+
+			if tt.canceled {
+				select {
+				case <-(<-opCtx).Done():
+				case <-time.After(time.Second):
+					t.Fatal("expected the operation to be canceled")
+				}
+			}
+
+			if calls := h.mockSvc.getCalls(); len(calls) != tt.subscribeCalls {
+				t.Fatalf("want %d subscribe calls, got %d", tt.subscribeCalls, len(calls))
+			}
+			close(h.conn.in)
+		})
+	}
+}
+
+func requireField(t *testing.T, msg json.RawMessage, name string) json.RawMessage {
+	t.Helper()
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		t.Fatalf("failed to unmarshal message: %v", err)
+	}
+
+	return fields[name]
+}
@@ -0,0 +1,365 @@
+package graphqlws
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	extensionCursor     = "cursor"
+	extensionResumeFrom = "resumeFrom"
+)
+
+// replayBuffer retains the most recent next payloads of every subscription
+// identity so that a client resubscribing with extensions.resumeFrom can be
+// sent the events it missed before live delivery continues.
+//
+// Every identity is subscribed to once: a producer records each event of the
+// upstream subscription and hands it to the operations of that identity. The
+// producer keeps running for the retention period after its last operation
+// ends, so that events are recorded while a client reconnects.
+type replayBuffer struct {
+	size      int
+	retention time.Duration
+	user      func(context.Context) string
+
+	mu        sync.Mutex
+	streams   map[string]*replayStream
+	lastPrune time.Time
+}
+
+// replayStream is the event history of a single subscription identity.
+// The epoch makes cursors issued by a pruned or recreated stream unusable.
+type replayStream struct {
+	epoch    string
+	seq      uint64
+	events   []replayEvent
+	touched  time.Time
+	producer *fanOut     // nil while the identity is not subscribed to
+	linger   *time.Timer // stops the producer once it has no listeners
+}
+
+// replayedPayload is a next payload recorded by a replayBuffer, stamped with
+// its cursor and encoded with the codec of the stream.
+type replayedPayload json.RawMessage
+
+type replayEvent struct {
+	seq     uint64
+	payload json.RawMessage
+}
+
+func newReplayBuffer(size int, retention time.Duration, user func(context.Context) string) *replayBuffer {
+	return &replayBuffer{
+		size:      max(size, 1),
+		retention: retention,
+		user:      user,
+		streams:   make(map[string]*replayStream),
+	}
+}
+
+// key returns the identity of a subscription: the user derived from the
+// connection context, the document, the operation name and the variables.
+// Events are stored encoded, so streams are also kept apart per codec.
+func (b *replayBuffer) key(ctx context.Context, codec Codec, payload subscribeMessagePayload) string {
+	var user string
+	if b.user != nil {
+		user = b.user(ctx)
+	}
+
+	// encoding/json sorts map keys, so equal variables produce equal bytes.
+	vars, _ := json.Marshal(payload.Variables)
+
+	return hashKey(codec.Name(), user, payload.Query, payload.OperationName, string(vars))
+}
+
+// hashKey returns a hash of parts that is unambiguous with respect to where
+// one part ends and the next begins.
+func hashKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(strconv.Itoa(len(part))))
+		h.Write([]byte{0})
+		h.Write([]byte(part))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// subscribe joins the producer of the stream of the operation, and starts it
+// with sub if it is not running. It returns the retained payloads recorded
+// after the cursor in extensions.resumeFrom, and a channel of the
+// replayedPayloads recorded after them.
+//
+// The producer subscribes with ctx without its cancellation, so the
+// subscription must not depend on context values other than the user.
+func (b *replayBuffer) subscribe(ctx context.Context, sub Subscriber, codec Codec, payload subscribeMessagePayload) ([]json.RawMessage, <-chan any, error) {
+	key := b.key(ctx, codec, payload)
+
+	b.mu.Lock()
+	s := b.stream(key, time.Now())
+	f := s.producer
+	if f == nil {
+		var upstream context.Context
+		f, upstream = newFanOut(ctx)
+		s.producer = f
+		go b.produce(upstream, sub, codec, s, f, payload)
+	}
+	if s.linger != nil {
+		s.linger.Stop()
+		s.linger = nil
+	}
+	l := f.join()
+
+	var missed []json.RawMessage
+	if cursor := payload.resumeFrom(); cursor != "" {
+		missed = s.since(cursor)
+	}
+	b.mu.Unlock()
+
+	if err := f.wait(ctx); err != nil {
+		b.leave(s, f, l)
+		return nil, nil, err
+	}
+
+	context.AfterFunc(ctx, func() { b.leave(s, f, l) })
+
+	return missed, l, nil
+}
+
+// produce records the events of the upstream subscription and hands them to
+// the listeners of f until the subscription ends or f is stopped.
+func (b *replayBuffer) produce(ctx context.Context, sub Subscriber, codec Codec, s *replayStream, f *fanOut, payload subscribeMessagePayload) {
+	c, ok := f.start(ctx, sub, payload.Query, payload.OperationName, payload.Variables, func() { b.forget(s, f) })
+	if !ok {
+		return
+	}
+
+	for v := range c {
+		encoded, err := encodePayload(codec, v)
+
+		b.mu.Lock()
+		if s.producer != f {
+			// Stopped, the upstream subscription is draining.
+			b.mu.Unlock()
+			continue
+		}
+
+		var ev any
+		if err != nil {
+			ev = eventError{fmt.Errorf("failed to marshal payload: %w", err)}
+		} else {
+			ev = replayedPayload(b.record(s, codec, encoded, time.Now()))
+		}
+		if f.send(ev) {
+			b.stopIdle(s, f)
+		}
+		b.mu.Unlock()
+	}
+
+	b.forget(s, f)
+	f.end()
+	f.stop()
+}
+
+// forget detaches the producer f from its stream, so that later operations
+// start a new one.
+func (b *replayBuffer) forget(s *replayStream, f *fanOut) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s.producer != f {
+		return
+	}
+	s.producer = nil
+	s.touched = time.Now()
+	if s.linger != nil {
+		s.linger.Stop()
+		s.linger = nil
+	}
+}
+
+// leave removes the listener l of f, and stops f if it was the last one.
+func (b *replayBuffer) leave(s *replayStream, f *fanOut, l chan any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if f.leave(l) {
+		b.stopIdle(s, f)
+	}
+}
+
+// stopIdle stops the producer f of s after the retention period, or right
+// away without one, unless an operation joins it in the meantime. The
+// caller must hold b.mu.
+func (b *replayBuffer) stopIdle(s *replayStream, f *fanOut) {
+	if s.producer != f || s.linger != nil || !f.idle() {
+		return
+	}
+
+	if b.retention <= 0 {
+		s.producer = nil
+		f.stop()
+		return
+	}
+
+	var linger *time.Timer
+	linger = time.AfterFunc(b.retention, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if s.linger != linger {
+			return
+		}
+		s.linger = nil
+		if s.producer == f && f.idle() {
+			s.producer = nil
+			s.touched = time.Now()
+			f.stop()
+		}
+	})
+	s.linger = linger
+}
+
+// encodePayload encodes v as a next payload.
+func encodePayload(codec Codec, v any) (json.RawMessage, error) {
+	if pre, ok := v.(*PreEncoded); ok {
+		return pre.payload(codec)
+	}
+	return codec.Marshal(v)
+}
+
+// stream returns the stream identified by key, creating it if needed. The
+// caller must hold b.mu.
+func (b *replayBuffer) stream(key string, now time.Time) *replayStream {
+	b.prune(now)
+
+	s, ok := b.streams[key]
+	if !ok {
+		s = &replayStream{epoch: newReplayEpoch(), touched: now}
+		b.streams[key] = s
+	}
+
+	return s
+}
+
+// record appends payload to s and returns it stamped with the event's cursor.
+// The caller must hold b.mu.
+func (b *replayBuffer) record(s *replayStream, codec Codec, payload json.RawMessage, now time.Time) json.RawMessage {
+	s.seq++
+	s.touched = now
+
+	stamped, err := withExtension(codec, payload, extensionCursor, formatCursor(s.epoch, s.seq))
+	if err != nil {
+		// Payloads that are not objects cannot carry a cursor. They
+		// are still delivered, but cannot be resumed from.
+		return payload
+	}
+
+	if len(s.events) == b.size {
+		copy(s.events, s.events[1:])
+		s.events = s.events[:len(s.events)-1]
+	}
+	s.events = append(s.events, replayEvent{seq: s.seq, payload: stamped})
+
+	return stamped
+}
+
+// since returns the retained payloads recorded after cursor. The caller must
+// hold the lock of the replayBuffer.
+func (s *replayStream) since(cursor string) []json.RawMessage {
+	epoch, seq, ok := parseCursor(cursor)
+	if !ok || s.epoch != epoch {
+		return nil
+	}
+
+	var out []json.RawMessage
+	for _, ev := range s.events {
+		if ev.seq > seq {
+			out = append(out, ev.payload)
+		}
+	}
+
+	return out
+}
+
+// prune drops streams that are not subscribed to and have not recorded an
+// event within the retention period. It runs at most once per retention
+// period.
+func (b *replayBuffer) prune(now time.Time) {
+	if b.retention <= 0 || now.Sub(b.lastPrune) < b.retention {
+		return
+	}
+	b.lastPrune = now
+
+	for key, s := range b.streams {
+		if s.producer == nil && now.Sub(s.touched) > b.retention {
+			delete(b.streams, key)
+		}
+	}
+}
+
+func newReplayEpoch() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func formatCursor(epoch string, seq uint64) string {
+	return epoch + ":" + strconv.FormatUint(seq, 10)
+}
+
+func parseCursor(cursor string) (epoch string, seq uint64, ok bool) {
+	epoch, n, found := strings.Cut(cursor, ":")
+	if !found {
+		return "", 0, false
+	}
+
+	seq, err := strconv.ParseUint(n, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return epoch, seq, true
+}
+
+// withExtension returns payload with extensions[key] set to value. The
+// payload must be an object encoded with codec.
+func withExtension(codec Codec, payload json.RawMessage, key string, value any) (json.RawMessage, error) {
+	var obj map[string]json.RawMessage
+	if err := codec.Unmarshal(payload, &obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, errors.New("payload is not an object")
+	}
+
+	var ext map[string]json.RawMessage
+	if raw, ok := obj["extensions"]; ok {
+		// A non-object extensions member is replaced.
+		_ = json.Unmarshal(raw, &ext)
+	}
+	if ext == nil {
+		ext = make(map[string]json.RawMessage)
+	}
+
+	v, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	ext[key] = v
+
+	if obj["extensions"], err = json.Marshal(ext); err != nil {
+		return nil, err
+	}
+
+	return codec.Marshal(obj)
+}
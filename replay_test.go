@@ -0,0 +1,319 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// record runs a subscription to payload on b whose upstream subscription
+// produces events. It returns the retained payloads replayed from
+// resumeFrom and the payloads recorded for the events.
+func record(t *testing.T, b *replayBuffer, payload subscribeMessagePayload, resumeFrom string, events ...string) (missed, recorded []json.RawMessage) {
+	t.Helper()
+
+	sub := &fakeTransportService{
+		subscribeFn: func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			c := make(chan any, len(events))
+			for _, ev := range events {
+				c <- json.RawMessage(ev)
+			}
+			close(c)
+			return c, nil
+		},
+	}
+	if resumeFrom != "" {
+		payload.Extensions = map[string]any{extensionResumeFrom: resumeFrom}
+	}
+
+	missed, c, err := b.subscribe(context.Background(), sub, JSONCodec{}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := range c {
+		p, ok := v.(replayedPayload)
+		if !ok {
+			t.Fatalf("unexpected event %v", v)
+		}
+		recorded = append(recorded, json.RawMessage(p))
+	}
+
+	return missed, recorded
+}
+
+func TestReplayBuffer(t *testing.T) {
+	t.Parallel()
+
+	payload := subscribeMessagePayload{Query: "subscription { ticks }", Variables: map[string]any{"a": 1, "b": 2}}
+
+	t.Run("stamps cursor and replays missed events", func(t *testing.T) {
+		t.Parallel()
+
+		b := newReplayBuffer(10, 0, nil)
+		_, recorded := record(t, b, payload, "", `{"data":{"n":1}}`, `{"data":{"n":2}}`, `{"data":{"n":3},"extensions":{"trace":true}}`)
+
+		missed, _ := record(t, b, payload, requireCursor(t, recorded[0]))
+		if len(missed) != 2 {
+			t.Fatalf("expected 2 missed events, got %d", len(missed))
+		}
+
+		var got struct {
+			Data       map[string]int `json:"data"`
+			Extensions map[string]any `json:"extensions"`
+		}
+		if err := json.Unmarshal(missed[1], &got); err != nil {
+			t.Fatalf("failed to unmarshal replayed payload: %v", err)
+		}
+		if got.Data["n"] != 3 || got.Extensions["trace"] != true || got.Extensions[extensionCursor] == nil {
+			t.Fatalf("unexpected replayed payload: %s", missed[1])
+		}
+	})
+
+	t.Run("evicts oldest events beyond size", func(t *testing.T) {
+		t.Parallel()
+
+		b := newReplayBuffer(2, 0, nil)
+		_, recorded := record(t, b, payload, "", `{"data":{"n":1}}`, `{"data":{}}`, `{"data":{}}`, `{"data":{}}`)
+
+		if missed, _ := record(t, b, payload, requireCursor(t, recorded[0])); len(missed) != 2 {
+			t.Fatalf("expected 2 retained events, got %d", len(missed))
+		}
+	})
+
+	t.Run("unknown cursors replay nothing", func(t *testing.T) {
+		t.Parallel()
+
+		b := newReplayBuffer(10, 0, nil)
+		record(t, b, payload, "", `{"data":{}}`)
+
+		for _, cursor := range []string{"garbage", "deadbeef:0"} {
+			if missed, _ := record(t, b, payload, cursor); len(missed) != 0 {
+				t.Fatalf("expected no events for cursor %q, got %d", cursor, len(missed))
+			}
+		}
+	})
+
+	t.Run("identity includes user and variables", func(t *testing.T) {
+		t.Parallel()
+
+		type userKey struct{}
+		b := newReplayBuffer(10, 0, func(ctx context.Context) string {
+			user, _ := ctx.Value(userKey{}).(string)
+			return user
+		})
+
+		alice := context.WithValue(context.Background(), userKey{}, "alice")
+		bob := context.WithValue(context.Background(), userKey{}, "bob")
+
+		if b.key(alice, JSONCodec{}, payload) == b.key(bob, JSONCodec{}, payload) {
+			t.Fatal("expected different keys for different users")
+		}
+
+		reordered := subscribeMessagePayload{Query: payload.Query, Variables: map[string]any{"b": 2, "a": 1}}
+		if b.key(alice, JSONCodec{}, payload) != b.key(alice, JSONCodec{}, reordered) {
+			t.Fatal("expected equal keys for equal variables")
+		}
+
+		other := subscribeMessagePayload{Query: payload.Query, Variables: map[string]any{"a": 2}}
+		if b.key(alice, JSONCodec{}, payload) == b.key(alice, JSONCodec{}, other) {
+			t.Fatal("expected different keys for different variables")
+		}
+	})
+
+	t.Run("idle streams are pruned", func(t *testing.T) {
+		t.Parallel()
+
+		b := newReplayBuffer(10, time.Millisecond, nil)
+		_, recorded := record(t, b, payload, "", `{"data":{}}`)
+
+		time.Sleep(5 * time.Millisecond)
+		record(t, b, subscribeMessagePayload{Query: "other"}, "", `{"data":{}}`)
+
+		if missed, _ := record(t, b, payload, requireCursor(t, recorded[0])); len(missed) != 0 {
+			t.Fatalf("expected cursor of pruned stream to be stale, got %d events", len(missed))
+		}
+	})
+}
+
+func TestReplayResume(t *testing.T) {
+	t.Parallel()
+
+	h := setupTest(t)
+	b := newReplayBuffer(10, 0, nil)
+
+	payload := subscribeMessagePayload{Query: "subscription { ticks }"}
+	_, recorded := record(t, b, payload, "", `{"data":{"n":1}}`, `{"data":{"n":2}}`)
+	first := recorded[0]
+
+	h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+		c := make(chan any, 1)
+		c <- json.RawMessage(`{"data":{"n":3}}`)
+		close(c)
+		return c, nil
+	}
+
+	go connectTransport(context.Background(), h.conn, h.mockSvc, transportReplay(b))
+
+	subscribe, _ := json.Marshal(map[string]any{
+		"id":   "1",
+		"type": "subscribe",
+		"payload": map[string]any{
+			"query":      payload.Query,
+			"extensions": map[string]any{extensionResumeFrom: requireCursor(t, first)},
+		},
+	})
+
+	go func() {
+		h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+		h.conn.in <- subscribe
+		time.Sleep(100 * time.Millisecond)
+		close(h.conn.in)
+	}()
+
+	messages := receiveTestMessages(t, h)
+	if len(messages) != 4 {
+		t.Fatalf("unexpected number of messages received: want=%d got=%d", 4, len(messages))
+	}
+
+	for i, want := range []int{2, 3} {
+		var msg struct {
+			Payload struct {
+				Data       map[string]int `json:"data"`
+				Extensions map[string]any `json:"extensions"`
+			} `json:"payload"`
+		}
+		if err := json.Unmarshal(messages[i+1], &msg); err != nil {
+			t.Fatalf("failed to unmarshal message: %v", err)
+		}
+		if msg.Payload.Data["n"] != want {
+			t.Fatalf("message %d: expected n=%d, got %s", i+1, want, messages[i+1])
+		}
+		if _, ok := msg.Payload.Extensions[extensionCursor].(string); !ok {
+			t.Fatalf("message %d: expected cursor extension, got %s", i+1, messages[i+1])
+		}
+	}
+
+	requireEqualJSON(t, `{"id":"1","type":"complete"}`, messages[3], "Message 3 mismatch")
+}
+
+func requireCursor(t *testing.T, payload json.RawMessage) string {
+	t.Helper()
+
+	var msg struct {
+		Extensions map[string]any `json:"extensions"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+
+	cursor, ok := msg.Extensions[extensionCursor].(string)
+	if !ok || cursor == "" {
+		t.Fatalf("expected cursor extension in %s", payload)
+	}
+
+	return cursor
+}
+
+func TestReplaySharedProducer(t *testing.T) {
+	t.Parallel()
+
+	b := newReplayBuffer(10, time.Minute, nil)
+	svc := &fakeTransportService{}
+	events := make(chan any, 1)
+	svc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+		return events, nil
+	}
+
+	// waitFor polls the stream of the subscription until cond holds.
+	waitFor := func(what string, cond func(s *replayStream) bool) {
+		t.Helper()
+
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			b.mu.Lock()
+			var ok bool
+			for _, s := range b.streams {
+				ok = cond(s)
+			}
+			b.mu.Unlock()
+
+			if ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	listeners := func(n int) func(s *replayStream) bool {
+		return func(s *replayStream) bool {
+			if s.producer == nil {
+				return false
+			}
+
+			s.producer.mu.Lock()
+			defer s.producer.mu.Unlock()
+			return len(s.producer.listeners) == n
+		}
+	}
+
+	connect := func(extensions string) *mockConnection {
+		conn := newMockConnection()
+		go connectTransport(context.Background(), conn, svc, transportReplay(b))
+
+		conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+		requireMessageType(t, requireMessage(t, conn), "connection_ack")
+		conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { ticks }","extensions":{` + extensions + `}}}`)
+		return conn
+	}
+	requireNext := func(conn *mockConnection, n int) string {
+		t.Helper()
+
+		var msg struct {
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(requireMessage(t, conn), &msg); err != nil {
+			t.Fatal(err)
+		}
+
+		var got struct {
+			Data map[string]int `json:"data"`
+		}
+		if err := json.Unmarshal(msg.Payload, &got); err != nil || got.Data["n"] != n {
+			t.Fatalf("want n=%d, got %s", n, msg.Payload)
+		}
+		return requireCursor(t, msg.Payload)
+	}
+
+	a, c := connect(""), connect("")
+	waitFor("both operations to join", listeners(2))
+
+	events <- map[string]any{"data": map[string]int{"n": 1}}
+	cursor := requireNext(a, 1)
+	if other := requireNext(c, 1); other != cursor {
+		t.Fatalf("want one recorded event, got cursors %q and %q", cursor, other)
+	}
+
+	close(a.in)
+	waitFor("the first operation to leave", listeners(1))
+	events <- map[string]any{"data": map[string]int{"n": 2}}
+	requireNext(c, 2)
+
+	// Without operations, the producer keeps recording.
+	close(c.in)
+	waitFor("the second operation to leave", listeners(0))
+	events <- map[string]any{"data": map[string]int{"n": 3}}
+	waitFor("the event to be recorded", func(s *replayStream) bool { return s.seq == 3 })
+
+	resumed := connect(`"resumeFrom":"` + cursor + `"`)
+	defer close(resumed.in)
+	requireNext(resumed, 2)
+	requireNext(resumed, 3)
+	waitFor("the resumed operation to join", listeners(1))
+	events <- map[string]any{"data": map[string]int{"n": 4}}
+	requireNext(resumed, 4)
+
+	if calls := svc.getCalls(); len(calls) != 1 {
+		t.Fatalf("want one upstream subscription, got %d", len(calls))
+	}
+}
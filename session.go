@@ -0,0 +1,229 @@
+package graphqlws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const sessionTokenKey = "sessionToken"
+
+// sessionStore keeps the operations of recently disconnected connections
+// alive for a grace period so that a reconnecting client can re-attach them
+// by presenting the session token it received in connection_ack.
+type sessionStore struct {
+	grace      time.Duration
+	maxPending int
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// session owns the operations of a connection independently of the socket.
+// While detached, messages produced by its operations are buffered until a
+// new connection attaches or the grace period expires.
+type session struct {
+	token  string
+	owner  string // of the connection that created the session
+	ctx    context.Context
+	cancel func()
+	codec  Codec
+	ops    operationMap
+	store  *sessionStore
+
+	mu      sync.Mutex
+	send    sendFunc
+	gen     uint64
+	pending []*operationMessage
+	expiry  *time.Timer
+	ended   bool
+}
+
+func newSessionStore(grace time.Duration, maxPending int) *sessionStore {
+	return &sessionStore{
+		grace:      grace,
+		maxPending: max(maxPending, 0),
+		sessions:   make(map[string]*session),
+	}
+}
+
+// create starts a new session whose operations inherit the values, but not
+// the cancellation, of ctx. Its messages are encoded with codec, and only
+// connections with the same owner may resume it.
+func (st *sessionStore) create(ctx context.Context, codec Codec, owner string) *session {
+	sctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	s := &session{
+		token:  newSessionToken(),
+		owner:  owner,
+		ctx:    sctx,
+		cancel: cancel,
+		codec:  codec,
+		ops:    newOperationMap(),
+		store:  st,
+	}
+
+	st.mu.Lock()
+	st.sessions[s.token] = s
+	st.mu.Unlock()
+
+	return s
+}
+
+// resume returns the session identified by token, if it is still alive, was
+// created by the same owner and its messages are encoded with a codec of the
+// same name as codec.
+func (st *sessionStore) resume(token string, codec Codec, owner string) (*session, bool) {
+	if token == "" {
+		return nil, false
+	}
+
+	st.mu.Lock()
+	s, ok := st.sessions[token]
+	st.mu.Unlock()
+
+	if !ok || s.owner != owner || s.codec.Name() != codec.Name() {
+		return nil, false
+	}
+
+	return s, true
+}
+
+// end cancels all operations of s and forgets it.
+func (st *sessionStore) end(s *session) {
+	s.mu.Lock()
+	s.stop()
+	s.mu.Unlock()
+
+	st.forget(s)
+}
+
+// forget removes the ended session s and cancels its operations.
+func (st *sessionStore) forget(s *session) {
+	st.mu.Lock()
+	delete(st.sessions, s.token)
+	st.mu.Unlock()
+
+	s.cancel()
+}
+
+// stop marks s as ended. The caller must hold s.mu.
+func (s *session) stop() {
+	s.ended = true
+	s.send = nil
+	s.pending = nil
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+}
+
+// release ends s on behalf of the connection that attached as gen, unless
+// another connection has taken over.
+func (s *session) release(gen uint64) {
+	s.mu.Lock()
+	current := !s.ended && s.gen == gen
+	if current {
+		s.stop()
+	}
+	s.mu.Unlock()
+
+	if current {
+		s.store.forget(s)
+	}
+}
+
+// attach acknowledges the connection with the session token and routes the
+// session's messages to send, flushing anything buffered while detached
+// first. A connection that is still attached is superseded. It returns the
+// attachment generation to pass to detach.
+func (s *session) attach(send sendFunc) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return 0, false
+	}
+
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+
+	send(&operationMessage{Type: typeConnectionAck, Payload: s.ackPayload()})
+	for _, msg := range s.pending {
+		send(msg)
+	}
+	s.pending = nil
+
+	s.gen++
+	s.send = send
+
+	return s.gen, true
+}
+
+// detach stops routing messages to the connection that attached as gen and
+// starts the grace period, unless another connection has taken over.
+func (s *session) detach(gen uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended || s.gen != gen {
+		return
+	}
+
+	s.send = nil
+	s.expiry = time.AfterFunc(s.store.grace, func() {
+		s.mu.Lock()
+		expired := !s.ended && s.gen == gen && s.send == nil
+		if expired {
+			s.stop()
+		}
+		s.mu.Unlock()
+
+		if expired {
+			s.store.forget(s)
+		}
+	})
+}
+
+// deliver is the sendFunc used by the session's operations.
+func (s *session) deliver(msg *operationMessage) bool {
+	s.mu.Lock()
+
+	if s.ended {
+		s.mu.Unlock()
+		return false
+	}
+
+	if s.send != nil && s.send(msg) {
+		s.mu.Unlock()
+		return true
+	}
+
+	// The socket is gone, or going away without having been detached yet.
+	s.send = nil
+	s.pending = append(s.pending, msg)
+	overflow := len(s.pending) > s.store.maxPending
+	s.mu.Unlock()
+
+	if overflow {
+		// Dropping messages silently would corrupt the resumed stream.
+		s.store.end(s)
+		return false
+	}
+
+	return true
+}
+
+func (s *session) ackPayload() json.RawMessage {
+	b, _ := s.codec.Marshal(map[string]string{sessionTokenKey: s.token})
+	return b
+}
+
+func newSessionToken() string {
+	var b [32]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
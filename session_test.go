@@ -0,0 +1,250 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSessionResumption(t *testing.T) {
+	t.Parallel()
+
+	t.Run("operations survive reconnect", func(t *testing.T) {
+		t.Parallel()
+
+		store := newSessionStore(time.Second, 10)
+		svc := &fakeTransportService{}
+		events := make(chan any)
+		svc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			return events, nil
+		}
+
+		first := newMockConnection()
+		go connectTransport(context.Background(), first, svc, transportSessions(store))
+
+		first.in <- json.RawMessage(`{"type":"connection_init"}`)
+		token := requireSessionAck(t, first)
+
+		first.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`)
+		events <- json.RawMessage(`{"data":{"n":1}}`)
+		requireEqualJSON(t, `{"id":"1","type":"next","payload":{"data":{"n":1}}}`, requireMessage(t, first), "first next")
+
+		// Drop the socket without a close frame.
+		close(first.in)
+		requireClosed(t, first)
+		requireDetached(t, store, token)
+
+		events <- json.RawMessage(`{"data":{"n":2}}`)
+
+		second := newMockConnection()
+		go connectTransport(context.Background(), second, svc, transportSessions(store))
+
+		second.in <- json.RawMessage(fmt.Sprintf(`{"type":"connection_init","payload":{"sessionToken":%q}}`, token))
+		if got := requireSessionAck(t, second); got != token {
+			t.Fatalf("expected resumed session token %q, got %q", token, got)
+		}
+		requireEqualJSON(t, `{"id":"1","type":"next","payload":{"data":{"n":2}}}`, requireMessage(t, second), "buffered next")
+
+		events <- json.RawMessage(`{"data":{"n":3}}`)
+		requireEqualJSON(t, `{"id":"1","type":"next","payload":{"data":{"n":3}}}`, requireMessage(t, second), "live next")
+
+		close(events)
+		requireEqualJSON(t, `{"id":"1","type":"complete"}`, requireMessage(t, second), "complete")
+
+		if calls := svc.getCalls(); len(calls) != 1 {
+			t.Fatalf("expected 1 Subscribe call, got %d", len(calls))
+		}
+
+		close(second.in)
+	})
+
+	t.Run("operations are cancelled after grace period", func(t *testing.T) {
+		t.Parallel()
+
+		store := newSessionStore(20*time.Millisecond, 10)
+		svc := &fakeTransportService{}
+		svc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			return make(chan any), nil
+		}
+
+		first := newMockConnection()
+		go connectTransport(context.Background(), first, svc, transportSessions(store))
+
+		first.in <- json.RawMessage(`{"type":"connection_init"}`)
+		token := requireSessionAck(t, first)
+		first.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`)
+
+		deadline := time.Now().Add(time.Second)
+		for len(svc.getCalls()) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for Subscribe call")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		close(first.in)
+		requireClosed(t, first)
+
+		select {
+		case <-svc.getCalls()[0].ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("expected operation context to be cancelled after grace period")
+		}
+
+		second := newMockConnection()
+		go connectTransport(context.Background(), second, svc, transportSessions(store))
+
+		second.in <- json.RawMessage(fmt.Sprintf(`{"type":"connection_init","payload":{"sessionToken":%q}}`, token))
+		if got := requireSessionAck(t, second); got == token {
+			t.Fatal("expected a new session token after grace period")
+		}
+
+		close(second.in)
+	})
+
+	t.Run("superseded connection does not end the session", func(t *testing.T) {
+		t.Parallel()
+
+		store := newSessionStore(time.Second, 10)
+		svc := &fakeTransportService{}
+		events := make(chan any)
+		svc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+			return events, nil
+		}
+
+		first := newMockConnection()
+		go connectTransport(context.Background(), first, svc, transportSessions(store))
+
+		first.in <- json.RawMessage(`{"type":"connection_init"}`)
+		token := requireSessionAck(t, first)
+		first.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { n }"}}`)
+		opCtx := svc.waitForCalls(1)[0].ctx
+
+		second := newMockConnection()
+		go connectTransport(context.Background(), second, svc, transportSessions(store))
+		defer close(second.in)
+
+		second.in <- json.RawMessage(fmt.Sprintf(`{"type":"connection_init","payload":{"sessionToken":%q}}`, token))
+		requireSessionAck(t, second)
+
+		// The superseded connection is closed for a protocol error.
+		first.in <- json.RawMessage(`{"type":"banana"}`)
+		requireClosed(t, first)
+
+		select {
+		case events <- json.RawMessage(`{"data":{"n":1}}`):
+		case <-opCtx.Done():
+			t.Fatal("expected the operation to keep running")
+		}
+		requireEqualJSON(t, `{"id":"1","type":"next","payload":{"data":{"n":1}}}`, requireMessage(t, second), "next")
+	})
+
+	t.Run("resume requires the same owner", func(t *testing.T) {
+		t.Parallel()
+
+		type userKey struct{}
+		store := newSessionStore(time.Second, 10)
+		owner := transportSessionOwner(func(ctx context.Context) string {
+			user, _ := ctx.Value(userKey{}).(string)
+			return user
+		})
+		connect := func(user, token string) (*mockConnection, string) {
+			conn := newMockConnection()
+			ctx := context.WithValue(context.Background(), userKey{}, user)
+			go connectTransport(ctx, conn, &fakeTransportService{}, transportSessions(store), owner)
+
+			conn.in <- json.RawMessage(fmt.Sprintf(`{"type":"connection_init","payload":{"sessionToken":%q}}`, token))
+			return conn, requireSessionAck(t, conn)
+		}
+
+		first, token := connect("alice", "")
+		close(first.in)
+		requireClosed(t, first)
+		requireDetached(t, store, token)
+
+		other, got := connect("mallory", token)
+		defer close(other.in)
+		if got == token {
+			t.Fatal("expected a new session for another owner")
+		}
+
+		same, got := connect("alice", token)
+		defer close(same.in)
+		if got != token {
+			t.Fatalf("expected resumed session token %q, got %q", token, got)
+		}
+	})
+
+	t.Run("buffer overflow ends session", func(t *testing.T) {
+		t.Parallel()
+
+		store := newSessionStore(time.Minute, 1)
+		s := store.create(context.Background(), JSONCodec{}, "")
+		gen, ok := s.attach(func(*operationMessage) bool { return true })
+		if !ok {
+			t.Fatal("expected attach to succeed")
+		}
+		s.detach(gen)
+
+		if !s.deliver(&operationMessage{ID: "1", Type: typeNext, Payload: json.RawMessage(`{}`)}) {
+			t.Fatal("expected first message to be buffered")
+		}
+		if s.deliver(&operationMessage{ID: "1", Type: typeNext, Payload: json.RawMessage(`{}`)}) {
+			t.Fatal("expected overflowing message to be rejected")
+		}
+
+		if s.ctx.Err() == nil {
+			t.Fatal("expected session context to be cancelled")
+		}
+		if _, ok := store.resume(s.token, JSONCodec{}, ""); ok {
+			t.Fatal("expected ended session to be forgotten")
+		}
+	})
+}
+
+func requireSessionAck(t *testing.T, conn *mockConnection) string {
+	t.Helper()
+
+	var ack struct {
+		Type    string `json:"type"`
+		Payload struct {
+			SessionToken string `json:"sessionToken"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(requireMessage(t, conn), &ack); err != nil {
+		t.Fatalf("failed to unmarshal ack: %v", err)
+	}
+	if ack.Type != string(typeConnectionAck) || ack.Payload.SessionToken == "" {
+		t.Fatalf("expected connection_ack with session token, got %+v", ack)
+	}
+
+	return ack.Payload.SessionToken
+}
+
+func requireDetached(t *testing.T, store *sessionStore, token string) {
+	t.Helper()
+
+	store.mu.Lock()
+	s, ok := store.sessions[token]
+	store.mu.Unlock()
+	if !ok {
+		t.Fatal("expected session to be alive")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		detached := s.send == nil
+		s.mu.Unlock()
+
+		if detached {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for session to detach")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
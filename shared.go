@@ -0,0 +1,248 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// SharedSubscriber runs a single upstream subscription for all operations
+// with the same document, operation name, variables and scope, and fans its
+// events out to every operation. The upstream subscription is started by the
+// first operation and canceled when the last one ends.
+//
+// Documents are compared after removing comments and insignificant
+// whitespace and commas. The scope function derives the part of the context
+// that the subscription's results depend on, for example the user or tenant;
+// operations in different scopes never share a subscription. A nil scope
+// shares subscriptions between all connections and must only be used for
+// public data.
+//
+// The upstream subscription is started with the context of the operation that
+// started it, without its cancellation, so it must not depend on context
+// values outside the scope. Operations that join a running subscription only
+// receive the events produced after they joined.
+//
+// Events are wrapped in a PreEncoded, so they are encoded once for all
+// operations. Every operation buffers up to 16 events; an operation that
+// falls further behind is ended with an error rather than delaying the
+// other operations of the subscription.
+type SharedSubscriber struct {
+	sub   Subscriber
+	scope func(context.Context) string
+
+	mu     sync.Mutex
+	shared map[string]*fanOut
+}
+
+var _ Subscriber = (*SharedSubscriber)(nil)
+
+// NewSharedSubscriber returns a SharedSubscriber that deduplicates the
+// subscriptions of sub within the scope returned by scope.
+func NewSharedSubscriber(sub Subscriber, scope func(context.Context) string) *SharedSubscriber {
+	return &SharedSubscriber{
+		sub:    sub,
+		scope:  scope,
+		shared: make(map[string]*fanOut),
+	}
+}
+
+// Unwrap returns the Subscriber that s deduplicates the subscriptions of.
+func (s *SharedSubscriber) Unwrap() Subscriber {
+	return s.sub
+}
+
+// Subscribe joins the running subscription for the document, operation and
+// variables, or starts it.
+func (s *SharedSubscriber) Subscribe(ctx context.Context, doc string, operation string, vars map[string]any) (<-chan any, error) {
+	key, err := s.key(ctx, doc, operation, vars)
+	if err != nil {
+		return s.sub.Subscribe(ctx, doc, operation, vars)
+	}
+
+	s.mu.Lock()
+	f, running := s.shared[key]
+	if !running {
+		var upstream context.Context
+		f, upstream = newFanOut(ctx)
+		s.shared[key] = f
+		go s.start(upstream, key, f, doc, operation, vars)
+	}
+	l := f.join()
+	s.mu.Unlock()
+
+	if err := f.wait(ctx); err != nil {
+		s.leave(key, f, l)
+		return nil, err
+	}
+
+	context.AfterFunc(ctx, func() { s.leave(key, f, l) })
+
+	return l, nil
+}
+
+// start subscribes upstream and fans the events out until the upstream
+// channel is closed.
+func (s *SharedSubscriber) start(ctx context.Context, key string, f *fanOut, doc string, operation string, vars map[string]any) {
+	c, ok := f.start(ctx, s.sub, doc, operation, vars, func() { s.forget(key, f) })
+	if !ok {
+		return
+	}
+
+	for v := range c {
+		if _, ok := v.(*PreEncoded); !ok {
+			v = NewPreEncoded(v)
+		}
+
+		if f.send(v) {
+			s.mu.Lock()
+			s.stopIdle(key, f)
+			s.mu.Unlock()
+		}
+	}
+
+	s.forget(key, f)
+	f.end()
+	f.stop()
+}
+
+// forget removes the subscription, so that later operations start a new one.
+func (s *SharedSubscriber) forget(key string, f *fanOut) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shared[key] == f {
+		delete(s.shared, key)
+	}
+}
+
+// leave removes the listener l from the subscription and cancels the
+// upstream subscription when l was its last listener.
+func (s *SharedSubscriber) leave(key string, f *fanOut, l chan any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f.leave(l) {
+		s.stopIdle(key, f)
+	}
+}
+
+// stopIdle removes the subscription and cancels the upstream subscription
+// if it has no listeners. s.mu must be held.
+func (s *SharedSubscriber) stopIdle(key string, f *fanOut) {
+	if !f.idle() {
+		return
+	}
+
+	if s.shared[key] == f {
+		delete(s.shared, key)
+	}
+	f.stop()
+}
+
+func (s *SharedSubscriber) key(ctx context.Context, doc string, operation string, vars map[string]any) (string, error) {
+	var scope string
+	if s.scope != nil {
+		scope = s.scope(ctx)
+	}
+
+	// encoding/json sorts map keys, so equal variables produce equal bytes.
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+
+	return hashKey(scope, normalizeDocument(doc), operation, string(b)), nil
+}
+
+// normalizeDocument removes the comments, commas and whitespace of a GraphQL
+// document that do not change its meaning, so that equivalent documents
+// compare equal. String values are kept as they are.
+func normalizeDocument(doc string) string {
+	var b strings.Builder
+	b.Grow(len(doc))
+
+	var (
+		separated bool // insignificant characters were skipped
+		last      byte // last byte written
+	)
+
+	for i := 0; i < len(doc); {
+		c := doc[i]
+
+		switch {
+		case c == '#':
+			for i < len(doc) && doc[i] != '\n' && doc[i] != '\r' {
+				i++
+			}
+			separated = true
+			continue
+
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+			separated = true
+			continue
+
+		case strings.HasPrefix(doc[i:], "\ufeff"):
+			i += len("\ufeff")
+			separated = true
+			continue
+		}
+
+		if separated && isWordByte(last) && isWordByte(c) {
+			b.WriteByte(' ')
+		}
+		separated = false
+
+		if c == '"' {
+			n := stringLen(doc[i:])
+			b.WriteString(doc[i : i+n])
+			last = '"'
+			i += n
+			continue
+		}
+
+		b.WriteByte(c)
+		last = c
+		i++
+	}
+
+	return b.String()
+}
+
+// isWordByte reports whether c can be part of a name or number, which must
+// stay separated from an adjacent name or number.
+func isWordByte(c byte) bool {
+	return c == '_' || c == '-' ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// stringLen returns the length of the string or block string value at the
+// start of s, or len(s) if it is not terminated.
+func stringLen(s string) int {
+	if strings.HasPrefix(s, `"""`) {
+		for i := 3; i < len(s); i++ {
+			switch {
+			case strings.HasPrefix(s[i:], `\"""`):
+				i += 3
+			case strings.HasPrefix(s[i:], `"""`):
+				return i + 3
+			}
+		}
+		return len(s)
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		case '\n', '\r':
+			return i
+		}
+	}
+
+	return len(s)
+}
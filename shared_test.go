@@ -0,0 +1,310 @@
+package graphqlws
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNormalizeDocument(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		a, b  string
+		equal bool
+	}{
+		"whitespace and commas": {
+			a:     "subscription { tick(symbol: \"GOPH\", limit: 1) { price } }",
+			b:     "subscription{\n\ttick(symbol:\"GOPH\" limit:1){price}\n}",
+			equal: true,
+		},
+		"comments": {
+			a:     "# prices\nsubscription { tick { price } } # trailing",
+			b:     "subscription { tick { price } }",
+			equal: true,
+		},
+		"separated names": {
+			a:     "subscription S { tick { ... on Tick { price } } }",
+			b:     "subscription S{tick{...on Tick{price}}}",
+			equal: true,
+		},
+		"names stay apart": {
+			a: "subscription { a b }",
+			b: "subscription { ab }",
+		},
+		"string whitespace": {
+			a: `subscription { tick(symbol: "GO PH") { price } }`,
+			b: `subscription { tick(symbol: "GOPH") { price } }`,
+		},
+		"comment in string": {
+			a: `subscription { tick(symbol: "#GOPH") { price } }`,
+			b: `subscription { tick(symbol: "") { price } }`,
+		},
+		"block string": {
+			a: "subscription { tick(symbol: \"\"\"a \\\"\"\" b\"\"\") { price } }",
+			b: "subscription { tick(symbol: \"\"\"a \\\"\"\"b\"\"\") { price } }",
+		},
+	}
+
+	for name, tt := range testTable {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			a, b := normalizeDocument(tt.a), normalizeDocument(tt.b)
+			if (a == b) != tt.equal {
+				t.Fatalf("want equal=%t, got %q and %q", tt.equal, a, b)
+			}
+		})
+	}
+}
+
+// upstreamSubscriber records the upstream subscriptions of a SharedSubscriber.
+type upstreamSubscriber struct {
+	err error
+
+	mu      sync.Mutex
+	streams []*upstreamStream
+}
+
+type upstreamStream struct {
+	ctx context.Context
+	c   chan any
+}
+
+func (s *upstreamSubscriber) Subscribe(ctx context.Context, doc string, operation string, vars map[string]any) (<-chan any, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	st := &upstreamStream{ctx: ctx, c: make(chan any)}
+	go func() {
+		<-ctx.Done()
+		close(st.c)
+	}()
+
+	s.mu.Lock()
+	s.streams = append(s.streams, st)
+	s.mu.Unlock()
+
+	return st.c, nil
+}
+
+func (s *upstreamSubscriber) getStreams() []*upstreamStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*upstreamStream(nil), s.streams...)
+}
+
+func requireNext(t *testing.T, c <-chan any, want any) {
+	t.Helper()
+
+	select {
+	case v, ok := <-c:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		p, ok := v.(*PreEncoded)
+		if !ok || p.Value() != want {
+			t.Fatalf("want %v, got %v", want, v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func requireChannelClosed(t *testing.T, c <-chan any) {
+	t.Helper()
+
+	select {
+	case _, ok := <-c:
+		if ok {
+			t.Fatal("expected the channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the channel to close")
+	}
+}
+
+func TestSharedSubscriber(t *testing.T) {
+	t.Parallel()
+
+	type userKey struct{}
+	scope := func(ctx context.Context) string {
+		user, _ := ctx.Value(userKey{}).(string)
+		return user
+	}
+	withUser := func(user string) (context.Context, context.CancelFunc) {
+		return context.WithCancel(context.WithValue(context.Background(), userKey{}, user))
+	}
+
+	t.Run("shares upstream subscription", func(t *testing.T) {
+		t.Parallel()
+
+		up := &upstreamSubscriber{}
+		s := NewSharedSubscriber(up, scope)
+
+		ctx1, cancel1 := withUser("a")
+		c1, err := s.Subscribe(ctx1, "subscription { tick }", "", map[string]any{"n": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx2, cancel2 := withUser("a")
+		c2, err := s.Subscribe(ctx2, "subscription {\n  tick\n}", "", map[string]any{"n": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		streams := up.getStreams()
+		if len(streams) != 1 {
+			t.Fatalf("expected one upstream subscription, got %d", len(streams))
+		}
+
+		streams[0].c <- "event"
+		requireNext(t, c1, "event")
+		requireNext(t, c2, "event")
+
+		cancel1()
+		streams[0].c <- "after leave"
+		requireNext(t, c2, "after leave")
+		if streams[0].ctx.Err() != nil {
+			t.Fatal("upstream subscription canceled while a listener remains")
+		}
+
+		cancel2()
+		select {
+		case <-streams[0].ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("upstream subscription not canceled after the last listener left")
+		}
+
+		ctx3, cancel3 := withUser("a")
+		defer cancel3()
+		if _, err := s.Subscribe(ctx3, "subscription { tick }", "", map[string]any{"n": 1}); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(up.getStreams()); n != 2 {
+			t.Fatalf("expected a new upstream subscription, got %d in total", n)
+		}
+	})
+
+	t.Run("stalled listener", func(t *testing.T) {
+		t.Parallel()
+
+		up := &upstreamSubscriber{}
+		s := NewSharedSubscriber(up, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stalled, _ := s.Subscribe(ctx, "subscription { tick }", "", nil)
+		c, _ := s.Subscribe(ctx, "subscription { tick }", "", nil)
+
+		// The stalled listener never reads, which must not hold up the
+		// other one.
+		upstream := up.getStreams()[0].c
+		n := fanOutBuffer + 5
+		for i := range n {
+			select {
+			case upstream <- i:
+			case <-time.After(time.Second):
+				t.Fatalf("fan-out blocked at event %d", i)
+			}
+			requireNext(t, c, i)
+		}
+
+		// The stalled listener gets the events it buffered, then an error
+		// that ends its operation.
+		for i := range fanOutBuffer {
+			requireNext(t, stalled, i)
+		}
+		select {
+		case v := <-stalled:
+			if ev, ok := v.(eventError); !ok || !errors.Is(ev.err, errSubscriptionLagged) {
+				t.Fatalf("want %v, got %v", errSubscriptionLagged, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the error")
+		}
+		requireChannelClosed(t, stalled)
+	})
+
+	t.Run("separate identities", func(t *testing.T) {
+		t.Parallel()
+
+		up := &upstreamSubscriber{}
+		s := NewSharedSubscriber(up, scope)
+
+		subscribe := func(user string, doc string, operation string, vars map[string]any) {
+			t.Helper()
+
+			ctx, cancel := withUser(user)
+			t.Cleanup(cancel)
+			if _, err := s.Subscribe(ctx, doc, operation, vars); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		subscribe("a", "subscription { tick }", "", nil)
+		subscribe("b", "subscription { tick }", "", nil)
+		subscribe("a", "subscription { tock }", "", nil)
+		subscribe("a", "subscription { tick }", "Named", nil)
+		subscribe("a", "subscription { tick }", "", map[string]any{"n": 2})
+		subscribe("a", "subscription { tick }", "", nil)
+
+		if n := len(up.getStreams()); n != 5 {
+			t.Fatalf("expected 5 upstream subscriptions, got %d", n)
+		}
+	})
+
+	t.Run("upstream completes", func(t *testing.T) {
+		t.Parallel()
+
+		up := &upstreamSubscriber{}
+		s := NewSharedSubscriber(up, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		c1, _ := s.Subscribe(ctx, "subscription { tick }", "", nil)
+		c2, _ := s.Subscribe(ctx, "subscription { tick }", "", nil)
+
+		// Canceling the upstream context closes its channel, as if the
+		// subscription ended on its own.
+		streams := up.getStreams()
+		s.mu.Lock()
+		for _, sh := range s.shared {
+			sh.cancel()
+		}
+		s.mu.Unlock()
+
+		requireChannelClosed(t, c1)
+		requireChannelClosed(t, c2)
+
+		if _, err := s.Subscribe(ctx, "subscription { tick }", "", nil); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(up.getStreams()); n != len(streams)+1 {
+			t.Fatalf("expected a new upstream subscription, got %d in total", n)
+		}
+	})
+
+	t.Run("upstream error", func(t *testing.T) {
+		t.Parallel()
+
+		wantErr := errors.New("upstream failed")
+		s := NewSharedSubscriber(&upstreamSubscriber{err: wantErr}, nil)
+
+		if _, err := s.Subscribe(context.Background(), "subscription { tick }", "", nil); !errors.Is(err, wantErr) {
+			t.Fatalf("want %v, got %v", wantErr, err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.shared) != 0 {
+			t.Fatalf("expected failed subscriptions to be removed, got %d", len(s.shared))
+		}
+	})
+}
@@ -8,9 +8,10 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
 )
 
 // operationMap holds active subscriptions.
@@ -60,46 +61,106 @@ const (
 )
 
 const (
+	closeCodeNormalClosure             = 1000
+	closeCodeGoingAway                 = 1001
 	closeCodeBadRequest                = 4400
 	closeCodeUnauthorized              = 4401
+	closeCodeForbidden                 = 4403
 	closeCodeConnectionInitTimeout     = 4408
 	closeCodeSubscriberAlreadyExists   = 4409
 	closeCodeTooManyInitialisationReqs = 4429
-	closeCodeInternalServerError       = websocket.CloseInternalServerErr
+	closeCodeInternalServerError       = 1011
+	closeCodeServiceRestart            = 1012
 )
 
+// closeCodeTooManyRequests closes connections exceeding a rate limit. The
+// protocol defines 4429 for repeated connection_init messages only.
+const closeCodeTooManyRequests = closeCodeTooManyInitialisationReqs
+
+// operationMessage is a protocol message. Payload is encoded with the codec of
+// the connection, JSON unless another codec was negotiated.
 type operationMessage struct {
 	ID      string               `json:"id,omitempty"`
 	Payload json.RawMessage      `json:"payload,omitempty"`
 	Type    operationMessageType `json:"type"`
+
+	// frame, if set, is the complete message, encoded once for all
+	// connections that send it.
+	frame *sharedFrame
 }
 
 type subscribeMessagePayload struct {
 	OperationName string         `json:"operationName"`
 	Query         string         `json:"query"`
 	Variables     map[string]any `json:"variables"`
+	Extensions    map[string]any `json:"extensions"`
+
+	document *validatedDocument // parsed when a feature needs it
 }
 
-type wsConnection interface {
-	Close() error
-	ReadJSON(v any) error
-	SetReadDeadline(t time.Time) error
-	SetReadLimit(limit int64)
-	SetWriteDeadline(t time.Time) error
-	WriteControl(messageType int, data []byte, deadline time.Time) error
-	WriteJSON(v any) error
+// resumeFrom returns the replay cursor requested through extensions.resumeFrom.
+func (p subscribeMessagePayload) resumeFrom() string {
+	cursor, _ := p.Extensions[extensionResumeFrom].(string)
+	return cursor
 }
 
 type connection struct {
+	batch        batchOptions
 	cancel       func()
+	codec        Codec
+	closeMu      sync.Mutex
+	closeCode    int
+	closeReason  string
 	maxOps       int
+	ratePolicies map[string]RatePolicy
 	readIdleTime time.Duration
+	replay       *replayBuffer
+	sessions     *sessionStore
+	sessionOwner func(context.Context) string
 	sub          Subscriber
 	writeTimeout time.Duration
-	ws           wsConnection
+	ws           Conn
+
+	messageBucket   *tokenBucket
+	subscribeBucket *tokenBucket
+	rateLimitAction RateLimitAction
+
+	connQuota       *outputQuota
+	keyQuota        *outputQuota
+	onQuotaExceeded func(key string)
+
+	validator *documentValidator
+	documents *documentCache
+
+	onSubscribe   OnSubscribeFunc
+	opMaxDuration time.Duration
+	opIdleTimeout time.Duration
+	timeoutAction TimeoutAction
+
+	age      connectionAge
+	draining atomic.Bool
+	inflight sync.WaitGroup // single-result operations, with a maximum age
+
+	initFunc    InitFunc
+	refreshFunc InitFunc
+
+	authorizer          Authorizer
+	authorizationHook   func(context.Context, *AuthorizationRequest, error)
+	authorizationSchema *graphql.Schema
+
+	audit      *auditOptions
+	id         string // identifies the connection in audit records, the DebugRegistry and profiles
+	remoteAddr string
+
+	debug  *debugConnection
+	queued atomic.Int64 // messages sent to the write loop and not yet written
+
+	profilerLabels bool
 }
 
-type sendFunc func(id string, omType operationMessageType, payload json.RawMessage)
+// sendFunc queues a message for writing. It reports false when the message
+// could not be queued because the connection is shutting down.
+type sendFunc func(msg *operationMessage) bool
 
 type transportOption func(conn *connection)
 
@@ -130,7 +191,38 @@ func transportMaxOperations(n int) transportOption {
 	}
 }
 
-func connectTransport(ctx context.Context, ws wsConnection, sub Subscriber, opts ...transportOption) {
+// transportCodec encodes the connection's messages with c.
+func transportCodec(c Codec) transportOption {
+	return func(conn *connection) {
+		conn.codec = c
+	}
+}
+
+// transportReplay stamps next payloads with replay cursors recorded in b and
+// serves missed events to operations resubscribing with a cursor.
+func transportReplay(b *replayBuffer) transportOption {
+	return func(conn *connection) {
+		conn.replay = b
+	}
+}
+
+// transportSessions lets operations outlive the socket so that a client
+// reconnecting with its session token can re-attach them.
+func transportSessions(st *sessionStore) transportOption {
+	return func(conn *connection) {
+		conn.sessions = st
+	}
+}
+
+// transportSessionOwner binds sessions to the identity owner derives from
+// the connection context.
+func transportSessionOwner(owner func(context.Context) string) transportOption {
+	return func(conn *connection) {
+		conn.sessionOwner = owner
+	}
+}
+
+func connectTransport(ctx context.Context, ws Conn, sub Subscriber, opts ...transportOption) {
 	conn := &connection{
 		sub: sub,
 		ws:  ws,
@@ -140,6 +232,7 @@ func connectTransport(ctx context.Context, ws wsConnection, sub Subscriber, opts
 		transportReadLimit(4096),
 		transportWriteTimeout(time.Second * 3),
 		transportMaxOperations(100),
+		transportCodec(JSONCodec{}),
 	}
 
 	for _, opt := range append(defaultOpts, opts...) {
@@ -148,32 +241,43 @@ func connectTransport(ctx context.Context, ws wsConnection, sub Subscriber, opts
 
 	ctx, cancel := context.WithCancel(ctx)
 	conn.cancel = cancel
-	conn.readLoop(ctx, conn.writeLoop(ctx))
+	conn.run(ctx, func(ctx context.Context) {
+		send := conn.writeLoop(ctx)
+		if conn.debug != nil {
+			defer conn.debug.registry.register(conn)()
+		}
+		conn.readLoop(ctx, send)
+	})
 }
 
 func (conn *connection) writeLoop(ctx context.Context) sendFunc {
 	stop := make(chan struct{})
 	out := make(chan *operationMessage, 1) // Using a small buffer can sometimes help, but is not essential for the fix.
 
-	send := func(id string, omType operationMessageType, payload json.RawMessage) {
+	send := func(msg *operationMessage) bool {
+		conn.queued.Add(1)
 		select {
 		case <-stop:
-			return
-		case out <- &operationMessage{ID: id, Type: omType, Payload: payload}:
+			conn.queued.Add(-1)
+			return false
+		case out <- msg:
+			return true
 		}
 	}
 
 	go func() {
 		defer close(stop)
-		defer conn.ws.Close()
+		defer func() {
+			// The close frame goes out after any messages still queued.
+			code, reason := conn.closeStatus()
+			_ = conn.ws.SetWriteDeadline(time.Now().Add(conn.writeTimeout))
+			_ = conn.ws.Close(code, reason)
+		}()
 
 		for {
 			select {
 			case msg := <-out:
-				if err := conn.ws.SetWriteDeadline(time.Now().Add(conn.writeTimeout)); err != nil {
-					return
-				}
-				if err := conn.ws.WriteJSON(msg); err != nil {
+				if err := conn.writeBatch(msg, out); err != nil {
 					return
 				}
 			case <-ctx.Done():
@@ -182,8 +286,9 @@ func (conn *connection) writeLoop(ctx context.Context) sendFunc {
 					select {
 					case msg := <-out:
 						// Still attempt to write pending messages
-						conn.ws.SetWriteDeadline(time.Now().Add(conn.writeTimeout))
-						if err := conn.ws.WriteJSON(msg); err != nil {
+						err := conn.write(msg)
+						conn.queued.Add(-1)
+						if err != nil {
 							// On error, we can't do much more, so exit.
 							return
 						}
@@ -199,19 +304,57 @@ func (conn *connection) writeLoop(ctx context.Context) sendFunc {
 	return send
 }
 
+func (conn *connection) write(msg *operationMessage) error {
+	if err := conn.ws.SetWriteDeadline(time.Now().Add(conn.writeTimeout)); err != nil {
+		return err
+	}
+
+	return conn.writeMessage(msg)
+}
+
+// writeMessage writes msg without setting the write deadline.
+func (conn *connection) writeMessage(msg *operationMessage) error {
+	conn.debug.touch()
+
+	if msg.frame != nil {
+		if fw, ok := conn.ws.(frameWriter); ok {
+			return fw.writeFrame(msg.frame)
+		}
+		return conn.ws.WriteMessage(msg.frame.typ, msg.frame.data)
+	}
+
+	data, err := conn.codec.EncodeMessage(msg.ID, string(msg.Type), msg.Payload)
+	if err != nil {
+		return err
+	}
+
+	return conn.ws.WriteMessage(conn.codec.MessageType(), data)
+}
+
 func (conn *connection) close() {
 	conn.cancel()
 }
 
+// closeWithCode shuts the connection down. The write loop sends a close frame
+// with code and reason once the messages queued so far are written. Only the
+// first code is sent.
 func (conn *connection) closeWithCode(code int, reason string) {
-	_ = conn.ws.WriteControl(
-		websocket.CloseMessage,
-		websocket.FormatCloseMessage(code, reason),
-		time.Now().Add(conn.writeTimeout),
-	)
+	conn.closeMu.Lock()
+	if conn.closeCode == 0 {
+		conn.closeCode, conn.closeReason = code, reason
+	}
+	conn.closeMu.Unlock()
+
 	conn.cancel()
 }
 
+func (conn *connection) closeStatus() (int, string) {
+	conn.closeMu.Lock()
+	defer conn.closeMu.Unlock()
+
+	return conn.closeCode, conn.closeReason
+}
+
 func (conn *connection) refreshReadDeadline() bool {
 	if conn.readIdleTime <= 0 {
 		return true
@@ -229,20 +372,45 @@ func (conn *connection) readLoop(ctx context.Context, send sendFunc) {
 	defer conn.close()
 
 	ops := newOperationMap()
+	opCtx, opSend := ctx, send
 	initDone := false
 	msgChan := make(chan *operationMessage, 1)
 	errChan := make(chan error, 1)
 
+	// With session resumption enabled, operations belong to a session that
+	// is detached rather than ended when the socket drops unexpectedly.
+	var (
+		sess       *session
+		sessionGen uint64
+		keepAlive  bool
+	)
+	defer func() {
+		if sess == nil {
+			return
+		}
+		if keepAlive {
+			sess.detach(sessionGen)
+			return
+		}
+		sess.release(sessionGen)
+	}()
+
 	go func() {
 		for {
-			var msg operationMessage
-			if err := conn.ws.ReadJSON(&msg); err != nil {
+			_, data, err := conn.ws.ReadMessage()
+			if err != nil {
+				errChan <- err
+				return
+			}
+
+			id, typ, payload, err := conn.codec.DecodeMessage(data)
+			if err != nil {
 				errChan <- err
 				return
 			}
 
 			select {
-			case msgChan <- &msg:
+			case msgChan <- &operationMessage{ID: id, Type: operationMessageType(typ), Payload: payload}:
 			case <-ctx.Done():
 				return
 			}
@@ -252,6 +420,12 @@ func (conn *connection) readLoop(ctx context.Context, send sendFunc) {
 	initTimer := time.NewTimer(conn.writeTimeout)
 	defer initTimer.Stop()
 
+	ageExpired, stopAgeTimer := conn.ageTimer()
+	defer stopAgeTimer()
+
+	var credentials expiryTimer
+	defer credentials.stop()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -260,12 +434,19 @@ func (conn *connection) readLoop(ctx context.Context, send sendFunc) {
 			// Read error occurred (e.g., client closed connection)
 			var netErr net.Error
 			if errors.As(err, &netErr) && netErr.Timeout() && initDone && conn.readIdleTime > 0 {
-				conn.closeWithCode(websocket.CloseNormalClosure, "Read idle timeout")
+				conn.closeWithCode(closeCodeNormalClosure, "Read idle timeout")
+				keepAlive = true
 				return
 			}
-			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && !errors.Is(err, io.EOF) && err.Error() != "connection closed" {
+			if !isCloseError(err, closeCodeNormalClosure, closeCodeGoingAway) && !errors.Is(err, io.EOF) && err.Error() != "connection closed" {
 				conn.closeWithCode(closeCodeBadRequest, "invalid message")
 			}
+			if sess != nil {
+				// A deliberate close by the client ends the session, anything
+				// else may be a network blip the client recovers from.
+				keepAlive = !isCloseError(err, closeCodeNormalClosure, closeCodeGoingAway)
+				return
+			}
 			// Find and cancel all active operations
 			ops.mu.Lock()
 			for id, cancel := range ops.ops {
@@ -274,6 +455,11 @@ func (conn *connection) readLoop(ctx context.Context, send sendFunc) {
 			}
 			ops.mu.Unlock()
 			return
+		case <-ageExpired:
+			conn.drain(ctx, send)
+		case <-credentials.expired():
+			conn.closeWithCode(closeCodeUnauthorized, "Unauthorized")
+			return
 		case <-initTimer.C:
 			if !initDone {
 				// Client failed to send connection_init in time.
@@ -281,6 +467,8 @@ func (conn *connection) readLoop(ctx context.Context, send sendFunc) {
 				return
 			}
 		case msg := <-msgChan:
+			conn.debug.touch()
+
 			if !initDone {
 				initTimer.Stop()
 
@@ -289,16 +477,48 @@ func (conn *connection) readLoop(ctx context.Context, send sendFunc) {
 					return
 				}
 
+				var initPayload map[string]any
 				if len(msg.Payload) > 0 {
-					var initPayload map[string]any
-					if err := json.Unmarshal(msg.Payload, &initPayload); err != nil {
+					if err := conn.codec.Unmarshal(msg.Payload, &initPayload); err != nil {
 						conn.closeWithCode(closeCodeBadRequest, "invalid connection_init payload")
 						return
 					}
 				}
 
-				// TODO: Add payload handling for auth here if needed
-				send("", typeConnectionAck, nil)
+				if conn.initFunc != nil {
+					initCtx, expiry, err := conn.initFunc(ctx, initPayload)
+					if err != nil {
+						conn.closeWithCode(closeCodeForbidden, "Forbidden")
+						return
+					}
+					opCtx = initCtx
+					credentials.reset(expiry)
+				}
+
+				if conn.sessions == nil {
+					send(&operationMessage{Type: typeConnectionAck})
+				} else {
+					var owner string
+					if conn.sessionOwner != nil {
+						owner = conn.sessionOwner(opCtx)
+					}
+
+					token, _ := initPayload[sessionTokenKey].(string)
+					resumed, ok := conn.sessions.resume(token, conn.codec, owner)
+					if ok {
+						sess = resumed
+					} else {
+						sess = conn.sessions.create(opCtx, conn.codec, owner)
+					}
+
+					if sessionGen, ok = sess.attach(send); !ok {
+						// The session expired while being resumed.
+						sess = conn.sessions.create(opCtx, conn.codec, owner)
+						sessionGen, _ = sess.attach(send)
+					}
+
+					ops, opCtx, opSend = sess.ops, sess.ctx, sess.deliver
+				}
 				initDone = true
 
 				if !conn.refreshReadDeadline() {
@@ -312,7 +532,26 @@ func (conn *connection) readLoop(ctx context.Context, send sendFunc) {
 				return
 			}
 
-			err := conn.processMessages(ctx, msg, send, ops)
+			allowed, open := conn.allowMessage(msg, opSend, ops)
+			if !open {
+				return
+			}
+			if !allowed {
+				continue
+			}
+
+			if msg.Type == typePing && conn.refreshFunc != nil {
+				if refreshed, expiry, ok := conn.refreshCredentials(ctx, msg.Payload); ok {
+					credentials.reset(expiry)
+					opCtx = refreshed
+					if sess != nil {
+						// Session operations still end with the session.
+						opCtx = valuesContext{Context: sess.ctx, values: refreshed}
+					}
+				}
+			}
+
+			err := conn.processMessages(opCtx, msg, opSend, ops)
 			if err != nil {
 				return
 			}
@@ -328,7 +567,7 @@ func (conn *connection) processMessages(ctx context.Context, msg *operationMessa
 		return errors.New("connection_init sent twice")
 
 	case typePing:
-		send("", typePong, msg.Payload)
+		send(&operationMessage{Type: typePong, Payload: msg.Payload})
 
 	case typePong:
 		// Pong can be sent unsolicited by either peer; ignore.
@@ -349,22 +588,47 @@ func (conn *connection) processMessages(ctx context.Context, msg *operationMessa
 			count := len(ops.ops)
 			ops.mu.RUnlock()
 			if count >= conn.maxOps {
-				send(msg.ID, typeError, errPayload(errors.New("too many concurrent subscriptions")))
+				send(&operationMessage{ID: msg.ID, Type: typeError, Payload: conn.errPayload(errors.New("too many concurrent subscriptions"))})
 				return nil
 			}
 		}
 
+		if conn.draining.Load() {
+			send(&operationMessage{ID: msg.ID, Type: typeError, Payload: conn.errPayload(errConnectionDraining)})
+			return nil
+		}
+
 		var payload subscribeMessagePayload
 
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		if err := conn.codec.Unmarshal(msg.Payload, &payload); err != nil {
 			conn.closeWithCode(closeCodeBadRequest, "invalid subscribe payload")
 			return errors.New("invalid subscribe payload")
 		}
 
+		if conn.validator != nil || conn.authorizer != nil || conn.age.age > 0 {
+			payload.document = conn.parse(payload.Query)
+		}
+
+		if conn.authorizer != nil {
+			if denied := conn.authorize(ctx, msg.ID, payload); denied != nil {
+				send(&operationMessage{ID: msg.ID, Type: typeError, Payload: denied})
+				return nil
+			}
+		}
+
 		opCtx, opCancel := context.WithCancel(ctx)
 		ops.add(msg.ID, opCancel)
 
-		go conn.runSubscription(opCtx, msg.ID, payload, send, ops)
+		if conn.age.age > 0 && singleResult(payload) {
+			conn.inflight.Add(1)
+			go func() {
+				defer conn.inflight.Done()
+				conn.runOperation(opCtx, msg.ID, payload, send, ops)
+			}()
+			return nil
+		}
+
+		go conn.runOperation(opCtx, msg.ID, payload, send, ops)
 
 	case typeComplete:
 		if msg.ID == "" {
@@ -388,43 +652,174 @@ func (conn *connection) processMessages(ctx context.Context, msg *operationMessa
 func (conn *connection) runSubscription(ctx context.Context, id string, payload subscribeMessagePayload, send sendFunc, ops operationMap) {
 	defer ops.delete(id)
 
-	c, err := conn.sub.Subscribe(ctx, payload.Query, payload.OperationName, payload.Variables)
+	audit := conn.startAudit(ctx, id, payload)
+	if audit != nil {
+		defer audit.finish(conn.audit.sink)
+		send = audit.wrap(send, conn.codec)
+	}
+
+	debugOp, endDebug := conn.debug.startOperation(id, payload.OperationName)
+	defer endDebug()
+	if debugOp != nil {
+		send = debugOp.wrap(send)
+	}
+
+	if conn.validator != nil {
+		if errs := conn.validator.validate(ctx, payload); errs != nil {
+			b, _ := conn.codec.Marshal(errs)
+			send(&operationMessage{ID: id, Type: typeError, Payload: b})
+			return
+		}
+	}
+
+	op := &Operation{
+		ID:            id,
+		OperationName: payload.OperationName,
+		Query:         payload.Query,
+		Variables:     payload.Variables,
+		Extensions:    payload.Extensions,
+		MaxDuration:   conn.opMaxDuration,
+		IdleTimeout:   conn.opIdleTimeout,
+	}
+	if conn.onSubscribe != nil {
+		if err := conn.onSubscribe(ctx, op); err != nil {
+			send(&operationMessage{ID: id, Type: typeError, Payload: conn.errPayload(err)})
+			return
+		}
+	}
+
+	policy, limited, err := conn.ratePolicy(payload)
+	if err != nil {
+		send(&operationMessage{ID: id, Type: typeError, Payload: conn.errPayload(err)})
+		return
+	}
+
+	var (
+		c      <-chan any
+		missed []json.RawMessage
+	)
+	if conn.replay != nil {
+		missed, c, err = conn.replay.subscribe(ctx, conn.sub, conn.codec, payload)
+	} else {
+		c, err = conn.sub.Subscribe(ctx, payload.Query, payload.OperationName, payload.Variables)
+	}
 	if err != nil {
-		send(id, typeError, errPayload(err))
+		send(&operationMessage{ID: id, Type: typeError, Payload: conn.errPayload(err)})
 		return
 	}
 	if c == nil {
-		send(id, typeError, errPayload(errors.New("subscriber returned nil channel")))
+		send(&operationMessage{ID: id, Type: typeError, Payload: conn.errPayload(errNilSubscription)})
 		return
 	}
+	if limited {
+		c = policy.apply(ctx, c)
+	}
+
+	var quotaKey string
+	if conn.keyQuota != nil {
+		quotaKey = conn.keyQuota.key(ctx)
+	}
+
+	for _, payload := range missed {
+		send(&operationMessage{ID: id, Type: typeNext, Payload: payload})
+	}
+
+	timer := newOperationTimer(op)
+	defer timer.stop()
+	maxDuration, idle := timer.expired()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-debugOp.stopped():
+			if opCancel, ok := ops.get(id); ok {
+				opCancel()
+			}
+			send(&operationMessage{ID: id, Type: typeError, Payload: conn.errPayload(errCanceledByOperator)})
+			return
+		case <-maxDuration:
+			conn.timeout(id, errOperationMaxDuration, send, ops)
+			audit.timeout()
+			return
+		case <-idle:
+			conn.timeout(id, errOperationIdle, send, ops)
+			audit.timeout()
+			return
 		case data, more := <-c:
+			timer.event()
 			if !more {
 				// Subscription stream closed
-				send(id, typeComplete, nil)
+				send(&operationMessage{ID: id, Type: typeComplete})
 				return
 			}
 
 			// Stream has data, send a 'next' message
-			jsonPayload, err := json.Marshal(data)
+			msg, err := conn.nextMessage(id, data)
 			if err != nil {
-				send(id, typeError, errPayload(fmt.Errorf("failed to marshal payload: %w", err)))
-				continue
+				// error is terminal, no further messages may be sent for id,
+				// so the Subscriber is told to stop producing them.
+				if opCancel, ok := ops.get(id); ok {
+					opCancel()
+				}
+				send(&operationMessage{ID: id, Type: typeError, Payload: conn.errPayload(err)})
+				return
+			}
+
+			if !conn.takeOutput(msg, quotaKey) {
+				if opCancel, ok := ops.get(id); ok {
+					opCancel()
+				}
+				send(&operationMessage{ID: id, Type: typeError, Payload: conn.errPayloadCode(errOutputQuota, errorCodeRateLimited)})
+				return
 			}
 
-			send(id, typeNext, jsonPayload)
+			send(msg)
+		}
+	}
+}
+
+// nextMessage encodes data as a next message for the operation id. A
+// PreEncoded value is encoded once per codec and sent as a frame shared
+// between connections. The events of a replayBuffer are already encoded and
+// stamped with their cursor. An eventError ends the operation with its error.
+func (conn *connection) nextMessage(id string, data any) (*operationMessage, error) {
+	msg := &operationMessage{ID: id, Type: typeNext}
+
+	var err error
+	switch v := data.(type) {
+	case replayedPayload:
+		msg.Payload = json.RawMessage(v)
+	case eventError:
+		return nil, v.err
+	case *PreEncoded:
+		if msg.frame, err = v.frame(conn.codec, id); err == nil {
+			msg.Payload, err = v.payload(conn.codec)
 		}
+	default:
+		msg.Payload, err = conn.codec.Marshal(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
+
+	return msg, nil
 }
 
-func errPayload(err error) json.RawMessage {
-	b, _ := json.Marshal([]map[string]string{{
+func (conn *connection) errPayload(err error) json.RawMessage {
+	b, _ := conn.codec.Marshal([]map[string]string{{
 		"message": err.Error(),
 	}})
 
 	return b
 }
+
+// errPayloadCode is like errPayload, with extensions.code set to code.
+func (conn *connection) errPayloadCode(err error, code string) json.RawMessage {
+	b, _ := conn.codec.Marshal([]map[string]any{{
+		"message":    err.Error(),
+		"extensions": map[string]string{"code": code},
+	}})
+
+	return b
+}
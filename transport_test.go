@@ -2,7 +2,6 @@ package graphqlws
 
 import (
 	"context"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,8 +12,6 @@ import (
 	"sync"
 	"testing"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 type transportSubscribeCall struct {
@@ -44,6 +41,19 @@ func (s *fakeTransportService) Subscribe(ctx context.Context, document string, o
 	return s.subscribeFn(ctx, document, operationName, variableValues)
 }
 
+// waitForCalls returns the recorded calls once there are at least n of them,
+// or after a second.
+func (s *fakeTransportService) waitForCalls(n int) []transportSubscribeCall {
+	deadline := time.Now().Add(time.Second)
+	for {
+		calls := s.getCalls()
+		if len(calls) >= n || time.Now().After(deadline) {
+			return calls
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func (s *fakeTransportService) getCalls() []transportSubscribeCall {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -77,15 +87,15 @@ func newMockConnection() *mockConnection {
 	}
 }
 
-// ReadJSON reads the next JSON-formatted message from the connection and unmarshals it
-func (ws *mockConnection) ReadJSON(v any) error {
+// ReadMessage returns the next message sent by the test
+func (ws *mockConnection) ReadMessage() (MessageType, []byte, error) {
 	for {
 		ws.mtx.Lock()
 		deadline := ws.readDeadline
 		ws.mtx.Unlock()
 
 		if !deadline.IsZero() && time.Now().After(deadline) {
-			return mockTimeoutError{}
+			return 0, nil, mockTimeoutError{}
 		}
 
 		wait := 10 * time.Millisecond
@@ -99,9 +109,9 @@ func (ws *mockConnection) ReadJSON(v any) error {
 		select {
 		case msg, ok := <-ws.in:
 			if !ok {
-				return errors.New("connection closed")
+				return 0, nil, errors.New("connection closed")
 			}
-			return json.Unmarshal(msg, v)
+			return TextMessage, msg, nil
 		case <-time.After(wait):
 		}
 	}
@@ -131,8 +141,8 @@ func (ws *mockConnection) SetReadDeadline(t time.Time) error {
 	return nil
 }
 
-// WriteJSON marshals the value v to JSON and sends it as a message over the connection
-func (ws *mockConnection) WriteJSON(v any) error {
+// WriteMessage hands a message written by the server to the test
+func (ws *mockConnection) WriteMessage(typ MessageType, data []byte) error {
 	ws.mtx.Lock()
 	defer ws.mtx.Unlock()
 
@@ -140,12 +150,7 @@ func (ws *mockConnection) WriteJSON(v any) error {
 		return errors.New("writing to closed connection")
 	}
 
-	data, err := json.Marshal(v)
-	if err != nil {
-		return err
-	}
-
-	ws.out <- data
+	ws.out <- append(json.RawMessage(nil), data...)
 	return nil
 }
 
@@ -158,28 +163,14 @@ func (ws *mockConnection) SetWriteDeadline(t time.Time) error {
 	return nil
 }
 
-func (ws *mockConnection) WriteControl(messageType int, data []byte, deadline time.Time) error {
-	if messageType != websocket.CloseMessage {
-		return nil
-	}
-
-	ws.mtx.Lock()
-	defer ws.mtx.Unlock()
-
-	if len(data) >= 2 {
-		ws.closeCode = int(binary.BigEndian.Uint16(data[:2]))
-		ws.closeReason = string(data[2:])
-	}
-
-	return nil
-}
-
-func (ws *mockConnection) Close() error {
+func (ws *mockConnection) Close(code int, reason string) error {
 	ws.mtx.Lock()
 	defer ws.mtx.Unlock()
 
 	if !ws.isClosed {
 		ws.isClosed = true
+		ws.closeCode = code
+		ws.closeReason = reason
 
 		close(ws.closeCalled)
 
@@ -312,6 +303,9 @@ func TestConnect(t *testing.T) {
 		serverMessages []string
 		assertClose    bool
 		closeCode      int
+		// subscribeCalls is the number of Subscribe calls to wait for
+		// before verifyCalls, as operations start asynchronously.
+		subscribeCalls int
 	}
 
 	testTable := map[string]struct {
@@ -474,6 +468,7 @@ func TestConnect(t *testing.T) {
 				serverMessages: []string{`{"type":"connection_ack"}`},
 				assertClose:    true,
 				closeCode:      closeCodeSubscriberAlreadyExists,
+				subscribeCalls: 1,
 			},
 			verifyCalls: func(t *testing.T, calls []transportSubscribeCall) {
 				if len(calls) != 1 {
@@ -614,7 +609,7 @@ func TestConnect(t *testing.T) {
 			want: Want{
 				serverMessages: []string{`{"type":"connection_ack"}`},
 				assertClose:    true,
-				closeCode:      websocket.CloseNormalClosure,
+				closeCode:      closeCodeNormalClosure,
 			},
 			verifyCalls: func(t *testing.T, calls []transportSubscribeCall) {
 				if len(calls) != 0 {
@@ -742,14 +737,14 @@ func TestConnect(t *testing.T) {
 			},
 			want: Want{assertClose: false},
 			verifyMsgs: func(t *testing.T, messages []json.RawMessage) {
-				if len(messages) != 3 {
-					t.Fatalf("unexpected number of messages received: want=%d got=%d", 3, len(messages))
+				// error terminates the operation, so no complete follows it.
+				if len(messages) != 2 {
+					t.Fatalf("unexpected number of messages received: want=%d got=%d", 2, len(messages))
 				}
 
 				requireEqualJSON(t, `{"type":"connection_ack"}`, messages[0], "Message 0 mismatch")
 				requireMessageType(t, messages[1], "error")
 				requireErrorMessageContains(t, messages[1], "failed to marshal payload")
-				requireEqualJSON(t, `{"id":"1","type":"complete"}`, messages[2], "Message 2 mismatch")
 			},
 			verifyCalls: func(t *testing.T, calls []transportSubscribeCall) {
 				if len(calls) != 1 {
@@ -844,12 +839,46 @@ func TestConnect(t *testing.T) {
 			}
 
 			if tt.verifyCalls != nil {
-				tt.verifyCalls(t, h.mockSvc.getCalls())
+				tt.verifyCalls(t, h.mockSvc.waitForCalls(tt.want.subscribeCalls))
 			}
 		})
 	}
 }
 
+func TestMarshalErrorCancelsOperation(t *testing.T) {
+	t.Parallel()
+
+	h := setupTest(t)
+	h.mockSvc.subscribeFn = func(ctx context.Context, document string, operationName string, variableValues map[string]any) (<-chan any, error) {
+		c := make(chan any)
+		go func() {
+			// The producer blocks until the operation is canceled.
+			for _, v := range []any{func() {}, "more"} {
+				select {
+				case c <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return c, nil
+	}
+
+	go connectTransport(context.Background(), h.conn, h.mockSvc)
+	defer close(h.conn.in)
+
+	h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+	requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+	h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`)
+	requireErrorMessageContains(t, requireMessage(t, h.conn), "failed to marshal payload")
+
+	select {
+	case <-h.mockSvc.waitForCalls(1)[0].ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("operation context not canceled after a marshal error")
+	}
+}
+
 func getMapKeys(m map[string]func()) []string {
 	keys := make([]string, 0, len(m))
 
@@ -861,6 +890,31 @@ func getMapKeys(m map[string]func()) []string {
 	return keys
 }
 
+func requireMessage(t *testing.T, conn *mockConnection) json.RawMessage {
+	t.Helper()
+
+	select {
+	case msg, ok := <-conn.out:
+		if !ok {
+			t.Fatal("connection closed while waiting for message")
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for server message")
+		return nil
+	}
+}
+
+func requireClosed(t *testing.T, conn *mockConnection) {
+	t.Helper()
+
+	select {
+	case <-conn.closeCalled:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for server to close connection")
+	}
+}
+
 func requireEqualJSON(t *testing.T, expected string, actual json.RawMessage, msg string) {
 	t.Helper()
 
@@ -0,0 +1,237 @@
+package graphqlws
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/ast"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// WithSchemaValidation parses and validates subscribe documents against the
+// schema before they are passed to the Subscriber, which must be a
+// *graphql.Schema of github.com/graph-gophers/graphql-go, or wrap one and
+// return it from an Unwrap() Subscriber method, as SharedSubscriber does.
+// Otherwise the option has no effect.
+//
+// Invalid documents, and documents without the requested operation or with
+// an operation type the schema does not offer, are answered with an error
+// message carrying GraphQL errors with locations, and are never subscribed.
+//
+// The results of the last cacheSize distinct documents are cached, and the
+// cached parse is shared with the Authorizer and WithMaxConnectionAge. Pass
+// 0 to disable the cache.
+func WithSchemaValidation(cacheSize int) Option {
+	cache := newDocumentCache(cacheSize)
+	return optionFunc(func(o *options) {
+		o.schemaValidation = true
+		o.documents = cache
+	})
+}
+
+// schemaOf returns the graphql-go schema that sub is or wraps.
+func schemaOf(sub Subscriber) *graphql.Schema {
+	for sub != nil {
+		switch s := sub.(type) {
+		case *graphql.Schema:
+			return s
+		case interface{ Unwrap() Subscriber }:
+			sub = s.Unwrap()
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// defaultDocumentCacheSize is the number of parsed documents cached when a
+// feature needs them and WithSchemaValidation does not set the size.
+const defaultDocumentCacheSize = 1000
+
+// transportDocuments caches the documents of subscribe payloads in c.
+func transportDocuments(c *documentCache) transportOption {
+	return func(conn *connection) {
+		conn.documents = c
+	}
+}
+
+// transportValidation validates subscribe payloads with v.
+func transportValidation(v *documentValidator) transportOption {
+	return func(conn *connection) {
+		conn.validator = v
+	}
+}
+
+// documentValidator validates documents against a schema and the operation
+// limits.
+type documentValidator struct {
+	schema         *graphql.Schema // nil if the Subscriber does not expose one
+	validateSchema bool
+	limits         *OperationLimits
+
+	// allowIntrospection reports whether introspection is allowed, if
+	// it is restricted.
+	allowIntrospection func(ctx context.Context) bool
+}
+
+// validatedDocument is the outcome of parsing, and possibly validating, a
+// document. doc is nil if the document could not be parsed.
+type validatedDocument struct {
+	doc  *document
+	errs []*gqlerrors.QueryError
+}
+
+// parse returns the parsed document of query, validated against the schema
+// with WithSchemaValidation. Documents are parsed once and cached.
+func (conn *connection) parse(query string) *validatedDocument {
+	var schema *graphql.Schema
+	if conn.validator != nil && conn.validator.validateSchema {
+		schema = conn.validator.schema
+	}
+
+	key := documentKey{schema: schema, hash: hashKey(query)}
+	d, ok := conn.documents.get(key)
+	if !ok {
+		d = parseQuery(schema, query)
+		conn.documents.add(key, d)
+	}
+
+	return &d
+}
+
+// validate returns the errors of the operation selected by payload, whose
+// document was parsed by the connection, if any.
+func (v *documentValidator) validate(ctx context.Context, payload subscribeMessagePayload) []*gqlerrors.QueryError {
+	d := payload.document
+	if d.errs != nil {
+		return d.errs
+	}
+
+	op, err := d.doc.operation(payload.OperationName)
+	if err != nil {
+		return []*gqlerrors.QueryError{gqlerrors.Errorf("%s", err)}
+	}
+
+	var schema *ast.Schema
+	if v.schema != nil {
+		schema = v.schema.ASTSchema()
+	}
+
+	if v.validateSchema {
+		if _, ok := schema.RootOperationTypes[op.typ]; !ok {
+			qErr := gqlerrors.Errorf("schema does not offer %s operations", op.typ)
+			qErr.Locations = []gqlerrors.Location{op.loc}
+			return []*gqlerrors.QueryError{qErr}
+		}
+	}
+
+	if v.allowIntrospection != nil {
+		if errs := v.checkIntrospection(ctx, d.doc, op); errs != nil {
+			return errs
+		}
+	}
+
+	if v.limits != nil {
+		return v.limits.check(schema, d.doc, op, payload.Variables)
+	}
+
+	return nil
+}
+
+// parseQuery parses query and, with a schema, validates it.
+func parseQuery(schema *graphql.Schema, query string) validatedDocument {
+	doc, err := parseDocument(query)
+	d := validatedDocument{doc: doc}
+
+	if schema != nil {
+		if errs := schema.Validate(query); len(errs) > 0 {
+			d.errs = errs
+			return d
+		}
+	}
+
+	if err != nil {
+		var qErr *gqlerrors.QueryError
+		if !errors.As(err, &qErr) {
+			qErr = gqlerrors.Errorf("%s", err)
+		}
+		d.errs = []*gqlerrors.QueryError{qErr}
+	}
+
+	return d
+}
+
+// documentKey identifies a document validated against a schema.
+type documentKey struct {
+	schema *graphql.Schema
+	hash   string
+}
+
+// documentCache is a least recently used cache of validated documents. A nil
+// *documentCache caches nothing.
+type documentCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[documentKey]*list.Element
+	order   *list.List // most recently used first
+}
+
+type documentCacheEntry struct {
+	key documentKey
+	doc validatedDocument
+}
+
+func newDocumentCache(size int) *documentCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &documentCache{
+		size:    size,
+		entries: make(map[documentKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *documentCache) get(key documentKey) (validatedDocument, bool) {
+	if c == nil {
+		return validatedDocument{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return validatedDocument{}, false
+	}
+	c.order.MoveToFront(e)
+
+	return e.Value.(*documentCacheEntry).doc, true
+}
+
+func (c *documentCache) add(key documentKey, doc validatedDocument) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&documentCacheEntry{key: key, doc: doc})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*documentCacheEntry).key)
+	}
+}
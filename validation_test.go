@@ -0,0 +1,200 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+const validationSchema = `
+	schema {
+		query: Query
+		subscription: Subscription
+	}
+
+	type Query {
+		hello: String!
+	}
+
+	type Subscription {
+		count(upTo: Int!): Int!
+	}
+`
+
+type validationResolver struct{}
+
+func (*validationResolver) Hello() string { return "hello" }
+
+func (*validationResolver) Count(ctx context.Context, args struct{ UpTo int32 }) <-chan int32 {
+	c := make(chan int32)
+	go func() {
+		defer close(c)
+		for i := int32(1); i <= args.UpTo; i++ {
+			select {
+			case c <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// countingSubscriber counts the subscriptions passed to the schema it wraps.
+type countingSubscriber struct {
+	schema *graphql.Schema
+	calls  atomic.Int32
+}
+
+func (s *countingSubscriber) Subscribe(ctx context.Context, doc string, operation string, vars map[string]any) (<-chan any, error) {
+	s.calls.Add(1)
+	return s.schema.Subscribe(ctx, doc, operation, vars)
+}
+
+func (s *countingSubscriber) Unwrap() Subscriber {
+	return s.schema
+}
+
+func TestSchemaValidation(t *testing.T) {
+	t.Parallel()
+
+	schema := graphql.MustParseSchema(validationSchema, &validationResolver{})
+	cache := newDocumentCache(10)
+
+	testTable := map[string]struct {
+		payload string
+		want    string // the error payload, if any
+	}{
+		"valid subscription": {
+			payload: `{"query":"subscription { count(upTo: 2) }"}`,
+		},
+		"selected operation": {
+			payload: `{"query":"subscription A { count(upTo: 1) } subscription B { count(upTo: 2) }","operationName":"B"}`,
+		},
+		"syntax error": {
+			payload: `{"query":"subscription {\n  count(upTo: 2\n}"}`,
+			want:    `[{"message":"syntax error: unexpected \"}\", expecting Ident","locations":[{"line":3,"column":1}]}]`,
+		},
+		"unknown field": {
+			payload: `{"query":"subscription {\n  ticks\n}"}`,
+			want:    `[{"message":"Cannot query field \"ticks\" on type \"Subscription\".","locations":[{"line":2,"column":3}]}]`,
+		},
+		"no operation name": {
+			payload: `{"query":"subscription A { count(upTo: 1) } subscription B { count(upTo: 2) }"}`,
+			want:    `[{"message":"more than one operation in query document and no operation name given"}]`,
+		},
+		"unknown operation name": {
+			payload: `{"query":"subscription A { count(upTo: 1) }","operationName":"B"}`,
+			want:    `[{"message":"no operation with name \"B\""}]`,
+		},
+		"operation type not offered": {
+			payload: `{"query":"query Q { hello } mutation M { hello }","operationName":"M"}`,
+			want:    `[{"message":"schema does not offer mutation operations","locations":[{"line":1,"column":19}]}]`,
+		},
+	}
+
+	for name, tt := range testTable {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := setupTest(t)
+			sub := &countingSubscriber{schema: schema}
+
+			go connectTransport(context.Background(), h.conn, sub, transportValidation(&documentValidator{schema: schemaOf(sub), validateSchema: true}), transportDocuments(cache))
+			defer close(h.conn.in)
+
+			h.conn.in <- json.RawMessage(`{"type":"connection_init"}`)
+			h.conn.in <- json.RawMessage(`{"id":"1","type":"subscribe","payload":` + tt.payload + `}`)
+			requireMessageType(t, requireMessage(t, h.conn), "connection_ack")
+
+			msg := requireMessage(t, h.conn)
+			if tt.want == "" {
+				requireMessageType(t, msg, "next")
+				if n := sub.calls.Load(); n != 1 {
+					t.Fatalf("expected one subscription, got %d", n)
+				}
+				return
+			}
+
+			requireEqualJSON(t, `{"id":"1","type":"error","payload":`+tt.want+`}`, msg, "")
+			if n := sub.calls.Load(); n != 0 {
+				t.Fatalf("expected invalid documents not to be subscribed, got %d", n)
+			}
+		})
+	}
+}
+
+func TestParseDocument(t *testing.T) {
+	t.Parallel()
+
+	doc, err := parseDocument("# comment\r\nquery Q($a: In = {x: [1, 2]}) { a(b: \"\"\"\nblock\n\"\"\") }\n" +
+		"fragment F on T { f }\n" +
+		"{ anonymous }\n" +
+		"\tsubscription S @live { ...F ... on T { g } }")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []operationDefinition{
+		{typ: "query", name: "Q", loc: gqlerrors.Location{Line: 2, Column: 1}},
+		{typ: "query", loc: gqlerrors.Location{Line: 6, Column: 1}},
+		{typ: "subscription", name: "S", loc: gqlerrors.Location{Line: 7, Column: 2}},
+	}
+	if len(doc.operations) != len(want) {
+		t.Fatalf("want %d operations, got %d", len(want), len(doc.operations))
+	}
+	for i, op := range doc.operations {
+		if op.typ != want[i].typ || op.name != want[i].name || op.loc != want[i].loc {
+			t.Fatalf("operation %d: want %+v, got %+v", i, want[i], *op)
+		}
+	}
+
+	if b := doc.operations[0].selections[0].arguments["b"]; b != "\nblock\n" {
+		t.Fatalf("want block string argument, got %q", b)
+	}
+	if f := doc.fragments["F"]; f == nil || f.typeCondition != "T" || f.selections[0].field != "f" {
+		t.Fatalf("want fragment F on T, got %+v", f)
+	}
+	if s := doc.operations[2].selections; len(s) != 2 || s[0].fragment != "F" || s[1].typeCondition != "T" {
+		t.Fatalf("want a fragment spread and an inline fragment, got %+v", s)
+	}
+
+	if _, err := parseDocument("subscription { a"); err == nil {
+		t.Fatal("expected an error for an unterminated document")
+	}
+}
+
+func TestDocumentCache(t *testing.T) {
+	t.Parallel()
+
+	c := newDocumentCache(2)
+	key := func(i int) documentKey { return documentKey{hash: fmt.Sprint(i)} }
+
+	c.add(key(1), validatedDocument{})
+	c.add(key(2), validatedDocument{})
+	if _, ok := c.get(key(1)); !ok {
+		t.Fatal("expected cached document")
+	}
+
+	// 2 is the least recently used document.
+	c.add(key(3), validatedDocument{})
+	if _, ok := c.get(key(2)); ok {
+		t.Fatal("expected the least recently used document to be evicted")
+	}
+	for _, i := range []int{1, 3} {
+		if _, ok := c.get(key(i)); !ok {
+			t.Fatalf("expected document %d to be cached", i)
+		}
+	}
+
+	var disabled *documentCache
+	disabled.add(key(1), validatedDocument{})
+	if _, ok := disabled.get(key(1)); ok {
+		t.Fatal("expected a nil cache to cache nothing")
+	}
+}